@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"net/http"
+	"pr-mail/app/service"
+	"pr-mail/pkg/api"
+	"pr-mail/pkg/e"
+)
+
+type AuthController interface {
+	Refresh(w http.ResponseWriter, r *http.Request)
+	Logout(w http.ResponseWriter, r *http.Request)
+}
+
+type AuthControllerImpl struct {
+	authService service.AuthService
+}
+
+func NewAuthController(authService service.AuthService) AuthController {
+	return &AuthControllerImpl{
+		authService: authService,
+	}
+}
+
+func (c *AuthControllerImpl) Refresh(w http.ResponseWriter, r *http.Request) {
+	resp, err := c.authService.RefreshSession(r)
+	if err != nil {
+		apiErr := e.NewAPIError(err, "failed to refresh session")
+		api.Fail(w, apiErr.StatusCode, apiErr.Code, apiErr.Message, err.Error())
+		return
+	}
+	api.Success(w, http.StatusOK, resp)
+}
+
+func (c *AuthControllerImpl) Logout(w http.ResponseWriter, r *http.Request) {
+	err := c.authService.Logout(r)
+	if err != nil {
+		apiErr := e.NewAPIError(err, "failed to logout admin")
+		api.Fail(w, apiErr.StatusCode, apiErr.Code, apiErr.Message, err.Error())
+		return
+	}
+	api.Success(w, http.StatusOK, "success")
+}
@@ -12,6 +12,7 @@ type PrController interface {
 	SaveEmployeePR(w http.ResponseWriter, r *http.Request)
 	GeneratePRDetails(w http.ResponseWriter, r *http.Request)
 	GeneratePRReport(w http.ResponseWriter, r *http.Request)
+	GetPRFiles(w http.ResponseWriter, r *http.Request)
 	SendPRMail(w http.ResponseWriter, r *http.Request)
 }
 
@@ -65,6 +66,16 @@ func (c *PrControllerImpl) GeneratePRReport(w http.ResponseWriter, r *http.Reque
 	api.Success(w, http.StatusOK, resp)
 }
 
+func (c *PrControllerImpl) GetPRFiles(w http.ResponseWriter, r *http.Request) {
+	resp, err := c.prService.GetPRFiles(r)
+	if err != nil {
+		apiErr := e.NewAPIError(err, "failed to get pr files")
+		api.Fail(w, apiErr.StatusCode, apiErr.Code, apiErr.Message, err.Error())
+		return
+	}
+	api.Success(w, http.StatusOK, resp)
+}
+
 func (c *PrControllerImpl) SendPRMail(w http.ResponseWriter, r *http.Request) {
 	err := c.prService.SendPRMail(r)
 	if err != nil {
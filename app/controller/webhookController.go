@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"net/http"
+	"pr-mail/app/dto"
+	"pr-mail/app/service"
+	"pr-mail/pkg/api"
+	"pr-mail/pkg/e"
+)
+
+type WebhookController interface {
+	GitHubWebhook(w http.ResponseWriter, r *http.Request)
+}
+
+type WebhookControllerImpl struct {
+	webhookService service.WebhookService
+}
+
+func NewWebhookController(webhookService service.WebhookService) WebhookController {
+	return &WebhookControllerImpl{
+		webhookService: webhookService,
+	}
+}
+
+func (c *WebhookControllerImpl) GitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	resp, err := c.webhookService.HandleGitHubWebhook(r)
+	if err != nil {
+		apiErr := e.NewAPIError(err, "failed to handle github webhook")
+		api.Fail(w, apiErr.StatusCode, apiErr.Code, apiErr.Message, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if resp.Status == dto.WebhookAccepted {
+		status = http.StatusAccepted
+	}
+	api.Success(w, status, resp)
+}
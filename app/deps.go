@@ -0,0 +1,32 @@
+package app
+
+import (
+	"pr-mail/app/github"
+	"pr-mail/app/provider"
+	"pr-mail/app/repo"
+	"pr-mail/app/service"
+
+	"gorm.io/gorm"
+)
+
+// Deps are the dependencies shared by the API and the background jobs. They
+// are built once per process so the GitHub client's token rate limit marks
+// and cached app installation tokens are shared by everything using it.
+type Deps struct {
+	prRepo    repo.PrRepo
+	ghClient  github.GitHubClient
+	providers *provider.Registry
+	refresher service.PRRefresher
+}
+
+func NewDeps(db *gorm.DB) Deps {
+	prRepo := repo.NewPrRepo(db)
+	ghClient := github.NewGitHubClient(github.ConfigFromEnv())
+	providers := provider.RegistryFromEnv()
+	return Deps{
+		prRepo:    prRepo,
+		ghClient:  ghClient,
+		providers: providers,
+		refresher: service.NewPRRefresher(prRepo, ghClient, providers, service.ConfigFromEnv()),
+	}
+}
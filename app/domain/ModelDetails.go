@@ -2,14 +2,73 @@ package domain
 
 import "time"
 
+// Aggregate review states stored in PullRequest.ReviewStatus and PRSnapshot.ReviewStatus
+const (
+	ReviewAwaiting         = "awaiting_review"
+	ReviewChangesRequested = "changes_requested"
+	ReviewApproved         = "approved"
+	ReviewCommented        = "commented"
+)
+
+// Summarized CI states stored in PRSnapshot.CIState
+const (
+	CIPending = "pending"
+	CISuccess = "success"
+	CIFailure = "failure"
+	CINone    = "none" // no statuses or check runs reported
+)
+
+// Canonical pull request statuses stored in PullRequest.Status
+const (
+	PRStatusOpen   = "open"
+	PRStatusDraft  = "draft"
+	PRStatusMerged = "merged"
+	PRStatusClosed = "closed"
+)
+
+// Webhook delivery states stored in WebhookDelivery.Status
+const (
+	DeliveryProcessing = "processing"
+	DeliveryProcessed  = "processed"
+	DeliveryFailed     = "failed" // let through again when redelivered
+)
+
 type Credential struct {
+	ID       uint   `gorm:"primaryKey"`
+	Username string `gorm:"unique;not null"`
+	Password string `gorm:"not null"`
+	Status   bool   `gorm:"default:true"`           // true = active
+	Role     string `gorm:"not null;default:admin"` // admin, manager or employee
+	// StaffID links a manager or employee login to their Employee.EmpID
+	StaffID string `gorm:"index"`
+	// SessionsRevokedAt ends every access token issued before it, set when the password changes
+	SessionsRevokedAt *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// RefreshToken is one rotation of a login's refresh token. Each refresh uses the
+// token up and issues the next one in the same family, presenting a used token
+// again means it leaked and the whole family is revoked.
+type RefreshToken struct {
 	ID        uint   `gorm:"primaryKey"`
-	Username  string `gorm:"unique;not null"`
-	Password  string `gorm:"not null"`
-	Token     string `gorm:"type:text"`    // store JWT token
-	Status    bool   `gorm:"default:true"` // true = active
+	AdminID   uint   `gorm:"index;not null"`
+	FamilyID  string `gorm:"index;not null"`
+	TokenHash string `gorm:"uniqueIndex;not null"` // sha256 of the token, the token itself is never stored
+	// AccessTokenID is the jti of the access token issued alongside, denied when the family is revoked
+	AccessTokenID        string `gorm:"index"`
+	AccessTokenExpiresAt time.Time
+	ExpiresAt            time.Time `gorm:"index;not null"`
+	UsedAt               *time.Time
+	RevokedAt            *time.Time
+	CreatedAt            time.Time
+}
+
+// RevokedToken denies an access token by jti until it would have expired anyway
+type RevokedToken struct {
+	JTI       string    `gorm:"column:jti;primaryKey"`
+	ExpiresAt time.Time `gorm:"index;not null"`
 	CreatedAt time.Time
-	UpdatedAt time.Time
 }
 
 type Employee struct {
@@ -21,6 +80,12 @@ type Employee struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 
+	// ManagerEmpID is the EmpID of the manager the employee reports to
+	ManagerEmpID string `gorm:"index"`
+
+	// GitHubLogin is used to discover PRs the employee didn't save
+	GitHubLogin string `gorm:"column:github_login;index"`
+
 	PullRequests []PullRequest `gorm:"foreignKey:EmployeeID"` // one-to-many relationship
 }
 
@@ -28,6 +93,7 @@ type PullRequest struct {
 	ID           uint   `gorm:"primaryKey"`
 	EmployeeID   uint   `gorm:"not null"` // FK to Employee
 	PRLink       string `gorm:"not null"` // Full PR URL
+	RepoOwner    string
 	RepoName     string
 	StaffID      string
 	PRNumber     int
@@ -40,25 +106,80 @@ type PullRequest struct {
 	FilesChanged int
 	CommitCount  int
 	BranchName   string
+	BaseBranch   string // branch the PR merges into
+	HeadSHA      string
 	ReviewStatus string
+	Reviewers    string // comma separated GitHub logins
 	CreatedAt    *time.Time
 	UpdatedAt    *time.Time
+	MergedAt     *time.Time
+	ClosedAt     *time.Time
 	FetchedAt    time.Time `gorm:"autoUpdateTime"` // Last time data was fetched
+	ETag         string    // GitHub ETag of the last fetch, sent as If-None-Match
+	LastModified string    // GitHub Last-Modified of the last fetch
+	// StatusETag is the ETag of the last status recheck, kept apart from ETag
+	// so a recheck doesn't hide other changes from the next full fetch
+	StatusETag string
+	// ReviewsETag and RequestedReviewersETag validate the stored review state,
+	// which changes without the PR's own ETag changing
+	ReviewsETag            string
+	RequestedReviewersETag string
+	// AutoDiscovered marks PRs found by the discovery job rather than saved by the employee
+	AutoDiscovered bool `gorm:"default:false"`
+}
+
+// PRStatusTransition records every status change seen when a PR is fetched
+type PRStatusTransition struct {
+	ID         uint      `gorm:"primaryKey"`
+	PRID       uint      `gorm:"not null;index"` // FK to PullRequest
+	FromStatus string    `gorm:"not null"`
+	ToStatus   string    `gorm:"not null"`
+	ChangedAt  time.Time `gorm:"not null"`
+	CreatedAt  time.Time
 }
 
 type PRSnapshot struct {
-	ID           uint `gorm:"primaryKey"`
-	EmployeeID   uint `gorm:"not null"`
-	Name         string
-	PRID         uint      `gorm:"not null"` // FK to PullRequest
-	Date         time.Time `gorm:"not null"` // date (e.g., 2025-07-16)
-	Description  string    `gorm:"not null"`
-	LinesAdded   int
-	LinesRemoved int
-	FilesChanged int
-	CommitCount  int
-	CreatedAt    time.Time
-	UpdatedAt    *time.Time
+	ID             uint `gorm:"primaryKey"`
+	EmployeeID     uint `gorm:"not null"`
+	Name           string
+	PRID           uint      `gorm:"not null"` // FK to PullRequest
+	Date           time.Time `gorm:"not null"` // date (e.g., 2025-07-16)
+	Description    string    `gorm:"not null"`
+	LinesAdded     int
+	LinesRemoved   int
+	FilesChanged   int
+	CommitCount    int
+	ReviewStatus   string
+	Reviewers      string // comma separated GitHub logins
+	CIState        string
+	FailingChecks  string // comma separated check names
+	FilesTruncated bool   // file list hit GitHub's 3000 file cap
+	CreatedAt      time.Time
+	UpdatedAt      *time.Time
+}
+
+// PRSnapshotFile is one file changed by the PR as of the snapshot's date
+type PRSnapshotFile struct {
+	ID         uint   `gorm:"primaryKey"`
+	SnapshotID uint   `gorm:"not null;index"` // FK to PRSnapshot
+	PRID       uint   `gorm:"not null"`       // FK to PullRequest
+	Path       string `gorm:"not null"`
+	Status     string // added, removed, modified, renamed, ...
+	Additions  int
+	Deletions  int
+	CreatedAt  time.Time
+}
+
+// PRCommit is a commit on the PR, kept so the daily report can list only new work
+type PRCommit struct {
+	ID          uint   `gorm:"primaryKey"`
+	PRID        uint   `gorm:"not null;uniqueIndex:idx_pr_commit"` // FK to PullRequest
+	SHA         string `gorm:"not null;uniqueIndex:idx_pr_commit"`
+	AuthorName  string
+	AuthorLogin string
+	Headline    string    // first line of the commit message
+	AuthoredAt  time.Time `gorm:"index"`
+	CreatedAt   time.Time
 }
 
 type PRReport struct {
@@ -71,3 +192,13 @@ type PRReport struct {
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 }
+
+// WebhookDelivery remembers GitHub deliveries so redeliveries of processed ones are skipped
+type WebhookDelivery struct {
+	ID         uint   `gorm:"primaryKey"`
+	DeliveryID string `gorm:"unique;not null"` // X-GitHub-Delivery
+	Event      string `gorm:"not null"`
+	Status     string `gorm:"not null;default:processed"` // one of the Delivery* states
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
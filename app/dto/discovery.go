@@ -0,0 +1,18 @@
+package dto
+
+// DiscoveryFailure is a search that failed for one employee and org, or a PR
+// found that couldn't be saved
+type DiscoveryFailure struct {
+	StaffID string `json:"staff_id"`
+	Login   string `json:"login"`
+	Org     string `json:"org"`
+	PRLink  string `json:"pr_link,omitempty"`
+	Message string `json:"message"`
+}
+
+// DiscoveryResponse summarizes a discovery run
+type DiscoveryResponse struct {
+	Created  []string           `json:"created"`  // links of the PRs added
+	Existing int                `json:"existing"` // PRs found that were already tracked
+	Failures []DiscoveryFailure `json:"failures,omitempty"`
+}
@@ -12,9 +12,9 @@ type SaveEmployeePRRequest struct {
 }
 
 type SinglePR struct {
-	Status  string `json:"status" validate:"required"`
+	Status  string `json:"status"` // ignored, new PRs start open and the first fetch sets the real status
 	StaffID string `json:"staff_id" validate:"required"`
-	PRLink  string `json:"pr_link" validate:"required,url"`
+	PRLink  string `json:"pr_link" validate:"required"` // a link or owner/repo#123, normalized by the service
 }
 
 func (args *SaveEmployeePRRequest) Parse(r *http.Request) error {
@@ -0,0 +1,28 @@
+package dto
+
+type GitHubCommitStatus struct {
+	Context string `json:"context"`
+	State   string `json:"state"` // error, failure, pending, success
+}
+
+type GitHubCombinedStatus struct {
+	State    string               `json:"state"`
+	Statuses []GitHubCommitStatus `json:"statuses"`
+}
+
+type GitHubCheckRun struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`     // queued, in_progress, completed
+	Conclusion string `json:"conclusion"` // success, failure, neutral, cancelled, skipped, timed_out, action_required
+}
+
+type GitHubCheckRuns struct {
+	TotalCount int              `json:"total_count"`
+	CheckRuns  []GitHubCheckRun `json:"check_runs"`
+}
+
+// GitHubCIData is the commit status and check runs reported for one commit
+type GitHubCIData struct {
+	Status    GitHubCombinedStatus
+	CheckRuns []GitHubCheckRun
+}
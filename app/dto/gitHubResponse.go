@@ -1,15 +1,26 @@
 package dto
 
+import "time"
+
 type GitHubPRResponse struct {
-	Title        string `json:"title"`
-	Body         string `json:"body"`
-	State        string `json:"state"` // open, closed, merged
-	Merged       bool   `json:"merged"`
-	ChangedFiles int    `json:"changed_files"`
-	Additions    int    `json:"additions"`
-	Deletions    int    `json:"deletions"`
-	Commits      int    `json:"commits"`
-	Description  string `json:"description"`
+	Title        string     `json:"title"`
+	Body         string     `json:"body"`
+	State        string     `json:"state"` // open or closed, merged PRs are reported as closed
+	Draft        bool       `json:"draft"`
+	Merged       bool       `json:"merged"`
+	MergedAt     *time.Time `json:"merged_at"`
+	ClosedAt     *time.Time `json:"closed_at"`
+	ChangedFiles int        `json:"changed_files"`
+	Additions    int        `json:"additions"`
+	Deletions    int        `json:"deletions"`
+	Commits      int        `json:"commits"`
+	Description  string     `json:"description"`
+
+	// Cache validators from the response headers, sent back on the next fetch
+	ETag         string `json:"-"`
+	LastModified string `json:"-"`
+	// NotModified is set when the data was rebuilt from storage after a 304
+	NotModified bool `json:"-"`
 
 	Base struct {
 		Ref string `json:"ref"` // target branch (e.g. master/main)
@@ -17,5 +28,27 @@ type GitHubPRResponse struct {
 
 	Head struct {
 		Ref string `json:"ref"` // source branch (e.g. feature/product-page)
+		SHA string `json:"sha"` // latest commit on the source branch
 	} `json:"head"`
 }
+
+type GitHubPRFile struct {
+	Filename  string `json:"filename"`
+	Status    string `json:"status"` // added, removed, modified, renamed, copied, changed, unchanged
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Changes   int    `json:"changes"`
+}
+
+type GitHubCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name  string    `json:"name"`
+			Email string    `json:"email"`
+			Date  time.Time `json:"date"`
+		} `json:"author"`
+	} `json:"commit"`
+	Author *GitHubUser `json:"author"` // nil when the commit email isn't linked to an account
+}
@@ -0,0 +1,28 @@
+package dto
+
+import "time"
+
+type GitHubUser struct {
+	Login string `json:"login"`
+}
+
+type GitHubTeam struct {
+	Slug string `json:"slug"`
+}
+
+type GitHubReview struct {
+	User        GitHubUser `json:"user"`
+	State       string     `json:"state"` // APPROVED, CHANGES_REQUESTED, COMMENTED, DISMISSED, PENDING
+	SubmittedAt *time.Time `json:"submitted_at"`
+}
+
+type GitHubRequestedReviewers struct {
+	Users []GitHubUser `json:"users"`
+	Teams []GitHubTeam `json:"teams"`
+}
+
+// GitHubReviewData is everything needed to work out where a PR's review stands
+type GitHubReviewData struct {
+	Reviews   []GitHubReview
+	Requested GitHubRequestedReviewers
+}
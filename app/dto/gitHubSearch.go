@@ -0,0 +1,17 @@
+package dto
+
+// GitHubSearchPR is a pull request as returned by the issue search API
+type GitHubSearchPR struct {
+	Number  int        `json:"number"`
+	Title   string     `json:"title"`
+	HTMLURL string     `json:"html_url"`
+	State   string     `json:"state"`
+	Draft   bool       `json:"draft"`
+	User    GitHubUser `json:"user"`
+}
+
+type GitHubSearchResponse struct {
+	TotalCount        int              `json:"total_count"`
+	IncompleteResults bool             `json:"incomplete_results"`
+	Items             []GitHubSearchPR `json:"items"`
+}
@@ -0,0 +1,82 @@
+package dto
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxWebhookPayload is GitHub's own cap on delivery size
+const maxWebhookPayload = 25 << 20
+
+// Webhook processing outcomes reported in WebhookResponse.Status
+const (
+	WebhookAccepted  = "accepted" // refreshed in the background
+	WebhookDuplicate = "duplicate"
+	WebhookIgnored   = "ignored"
+)
+
+type GitHubWebhookRequest struct {
+	Event      string
+	DeliveryID string
+	Signature  string
+	Body       []byte
+}
+
+type GitHubWebhookRepository struct {
+	Name    string     `json:"name"`
+	Owner   GitHubUser `json:"owner"`
+	HTMLURL string     `json:"html_url"`
+}
+
+type GitHubWebhookPR struct {
+	Number  int        `json:"number"`
+	HTMLURL string     `json:"html_url"`
+	State   string     `json:"state"` // open or closed, not sent in check_suite events
+	Draft   bool       `json:"draft"`
+	Title   string     `json:"title"`
+	User    GitHubUser `json:"user"`
+}
+
+// GitHubWebhookPayload holds the fields used from pull_request, pull_request_review and check_suite events
+type GitHubWebhookPayload struct {
+	Action      string                  `json:"action"`
+	Repository  GitHubWebhookRepository `json:"repository"`
+	PullRequest *GitHubWebhookPR        `json:"pull_request"`
+	CheckSuite  *struct {
+		HeadSHA      string            `json:"head_sha"`
+		PullRequests []GitHubWebhookPR `json:"pull_requests"`
+	} `json:"check_suite"`
+}
+
+type WebhookResponse struct {
+	Event      string `json:"event"`
+	DeliveryID string `json:"delivery_id"`
+	Status     string `json:"status"`
+}
+
+func (args *GitHubWebhookRequest) Parse(r *http.Request) error {
+	args.Event = r.Header.Get("X-GitHub-Event")
+	args.DeliveryID = r.Header.Get("X-GitHub-Delivery")
+	args.Signature = r.Header.Get("X-Hub-Signature-256")
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookPayload))
+	if err != nil {
+		return err
+	}
+	args.Body = body
+	return nil
+}
+
+func (args *GitHubWebhookRequest) Validate() error {
+	if args.Event == "" {
+		return fmt.Errorf("X-GitHub-Event header is missing")
+	}
+	if args.DeliveryID == "" {
+		return fmt.Errorf("X-GitHub-Delivery header is missing")
+	}
+	if args.Signature == "" {
+		return fmt.Errorf("X-Hub-Signature-256 header is missing")
+	}
+	return nil
+}
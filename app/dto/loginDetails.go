@@ -3,6 +3,7 @@ package dto
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-playground/validator"
 )
@@ -13,7 +14,10 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token            string    `json:"token"`
+	TokenExpiresAt   time.Time `json:"token_expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
 }
 
 func (args *LoginRequest) Parse(r *http.Request) error {
@@ -0,0 +1,63 @@
+package dto
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator"
+)
+
+type PRFilesRequest struct {
+	StaffID string    `json:"staff_id" validate:"required"`
+	Date    time.Time `json:"date"`
+}
+
+type PRFile struct {
+	Path      string `json:"path"`
+	Status    string `json:"status"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+}
+
+type PRFileList struct {
+	PRLink    string   `json:"pr_link"`
+	Title     string   `json:"title"`
+	Truncated bool     `json:"truncated"`
+	Files     []PRFile `json:"files"`
+}
+
+type PRFilesResponse struct {
+	EmpID string       `json:"emp_id"`
+	Date  string       `json:"date"`
+	PRs   []PRFileList `json:"prs"`
+}
+
+// Parse reads the staff id from the path and an optional ?date=YYYY-MM-DD, defaulting to today
+func (args *PRFilesRequest) Parse(r *http.Request) error {
+	strID := chi.URLParam(r, "id")
+	if strID == "" {
+		return fmt.Errorf("id parameter is missing or empty")
+	}
+	args.StaffID = strID
+
+	args.Date = time.Now()
+	if strDate := r.URL.Query().Get("date"); strDate != "" {
+		date, err := time.Parse("2006-01-02", strDate)
+		if err != nil {
+			return fmt.Errorf("date must be in YYYY-MM-DD format: %w", err)
+		}
+		args.Date = date
+	}
+	return nil
+}
+
+func (args *PRFilesRequest) Validate() error {
+	validate := validator.New()
+	err := validate.Struct(args)
+	if err != nil {
+		return err
+	}
+	return nil
+}
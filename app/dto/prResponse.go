@@ -15,21 +15,47 @@ type PRDetailsEmployeeID struct {
 // New: Struct for a single PR's details
 // Used for multiple PRs in PRDetailsResponse
 type SinglePRDetails struct {
-	Owner        string `json:"owner"`
-	Title        string `json:"title"`
-	Description  string `json:"description"`
-	Status       string `json:"status"`
-	IsMerged     bool   `json:"is_merged"`
-	Files        int    `json:"files_changed"`
-	LinesAdded   int    `json:"lines_added"`
-	LinesRemoved int    `json:"lines_removed"`
-	CommitCount  int    `json:"commit_count"`
-	Branch       string `json:"branch"`
-	PRLink       string `json:"pr_link"`
+	PRID          uint     `json:"pr_id"`
+	Owner         string   `json:"owner"`
+	Title         string   `json:"title"`
+	Description   string   `json:"description"`
+	Status        string   `json:"status"`
+	IsMerged      bool     `json:"is_merged"`
+	Files         int      `json:"files_changed"`
+	LinesAdded    int      `json:"lines_added"`
+	LinesRemoved  int      `json:"lines_removed"`
+	CommitCount   int      `json:"commit_count"`
+	Branch        string   `json:"branch"`
+	PRLink        string   `json:"pr_link"`
+	ReviewStatus  string   `json:"review_status"`
+	Reviewers     []string `json:"reviewers"`
+	CIState       string   `json:"ci_state"`
+	FailingChecks []string `json:"failing_checks"`
+	// AutoDiscovered PRs were found by search and not yet confirmed by the employee
+	AutoDiscovered bool `json:"auto_discovered"`
+}
+
+// Failure categories reported in PRFetchFailure
+const (
+	FailureBadLink     = "bad_link"
+	FailureNotFound    = "not_found"
+	FailureAuth        = "auth"
+	FailureRateLimited = "rate_limited"
+	FailureNetwork     = "network"
+	FailureStore       = "store" // fetched but saving it failed
+	FailureUnknown     = "unknown"
+)
+
+// PRFetchFailure describes a PR that could not be fetched or saved
+type PRFetchFailure struct {
+	PRLink   string `json:"pr_link"`
+	Category string `json:"category"`
+	Message  string `json:"message"`
 }
 
 type PRDetailsResponse struct {
-	PRs []SinglePRDetails `json:"prs"`
+	PRs      []SinglePRDetails `json:"prs"`
+	Failures []PRFetchFailure  `json:"failures"`
 }
 
 func (args *PRDetailsEmployeeID) Parse(r *http.Request) error {
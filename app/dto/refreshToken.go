@@ -0,0 +1,30 @@
+package dto
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-playground/validator"
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+func (args *RefreshTokenRequest) Parse(r *http.Request) error {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&args)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (args *RefreshTokenRequest) Validate() error {
+	validate := validator.New()
+	err := validate.Struct(args)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package dto
+
+// PRStatusChange is a PR whose status changed during a sync
+type PRStatusChange struct {
+	PRID       uint   `json:"pr_id"`
+	PRLink     string `json:"pr_link"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+}
+
+// StatusSyncResponse summarizes a status sync run
+type StatusSyncResponse struct {
+	Checked  int              `json:"checked"`
+	Changed  []PRStatusChange `json:"changed"`
+	Failures []PRFetchFailure `json:"failures,omitempty"`
+}
@@ -0,0 +1,329 @@
+package github
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// appJWTLifetime stays under GitHub's 10 minute maximum for app JWTs
+	appJWTLifetime = 9 * time.Minute
+
+	// installationTokenMargin renews installation tokens this long before they expire
+	installationTokenMargin = 5 * time.Minute
+
+	// installationMissTTL is how long an owner without an installation is
+	// remembered before the installations are listed again for it
+	installationMissTTL = 10 * time.Minute
+
+	// appCallTimeout bounds a token mint or installation listing shared by
+	// several callers, which runs apart from any one caller's context
+	appCallTimeout = 2 * time.Minute
+)
+
+// ErrAppNotInstalled is returned by AppTokenSource for owners without an installation
+var ErrAppNotInstalled = errors.New("github app is not installed")
+
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// maxInstallationPages bounds listing the app's installations, 100 per page
+const maxInstallationPages = 100
+
+// AppTokenSource authenticates as a GitHub App. It signs an app JWT with the
+// private key, finds the installation for the repository owner and exchanges
+// the JWT for an installation access token, cached until shortly before expiry.
+type AppTokenSource struct {
+	appID   int64
+	key     *rsa.PrivateKey
+	baseURL string
+	http    *http.Client
+
+	// group lets one caller list installations or mint a token while the
+	// others for the same key wait for its result
+	group singleflight.Group
+
+	mu            sync.Mutex           // guards the caches, never held across a request
+	installations map[string]int64     // lowercased account login -> installation id
+	misses        map[string]time.Time // lowercased login -> when it had no installation
+	tokens        map[int64]installationToken
+}
+
+func NewAppTokenSource(appID int64, key *rsa.PrivateKey, baseURL string, transport http.RoundTripper) *AppTokenSource {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &AppTokenSource{
+		appID:         appID,
+		key:           key,
+		baseURL:       baseURL,
+		http:          &http.Client{Timeout: DefaultTimeout, Transport: transport},
+		installations: map[string]int64{},
+		misses:        map[string]time.Time{},
+		tokens:        map[int64]installationToken{},
+	}
+}
+
+// AppWithFallback is a TokenSource that authenticates as the GitHub App for
+// owners that installed it and with the fallback tokens, such as a
+// TokenRegistry, for everyone else
+type AppWithFallback struct {
+	app      *AppTokenSource
+	fallback TokenSource
+}
+
+func NewAppWithFallback(app *AppTokenSource, fallback TokenSource) *AppWithFallback {
+	return &AppWithFallback{app: app, fallback: fallback}
+}
+
+func (s *AppWithFallback) Token(ctx context.Context, owner string) (string, error) {
+	token, err := s.app.Token(ctx, owner)
+	if errors.Is(err, ErrAppNotInstalled) {
+		return s.fallback.Token(ctx, owner)
+	}
+	return token, err
+}
+
+// MarkRateLimited only concerns the fallback tokens, an installation has a single token
+func (s *AppWithFallback) MarkRateLimited(owner, token string, resetAt time.Time) {
+	if reporter, ok := s.fallback.(RateLimitReporter); ok {
+		reporter.MarkRateLimited(owner, token, resetAt)
+	}
+}
+
+func (s *AppWithFallback) PoolSize(owner string) int {
+	if _, ok := s.app.cachedInstallation(strings.ToLower(owner)); ok {
+		return 1
+	}
+	if reporter, ok := s.fallback.(RateLimitReporter); ok {
+		return reporter.PoolSize(owner)
+	}
+	return 1
+}
+
+func (s *AppWithFallback) InvalidateToken(owner, token string) {
+	s.app.InvalidateToken(owner, token)
+	if invalidator, ok := s.fallback.(TokenInvalidator); ok {
+		invalidator.InvalidateToken(owner, token)
+	}
+}
+
+// ParseAppPrivateKey reads the PEM encoded RSA key downloaded from the app settings
+func ParseAppPrivateKey(pem []byte) (*rsa.PrivateKey, error) {
+	return jwtgo.ParseRSAPrivateKeyFromPEM(pem)
+}
+
+func (s *AppTokenSource) Token(ctx context.Context, owner string) (string, error) {
+	installationID, err := s.installationFor(ctx, owner)
+	if err != nil {
+		return "", err
+	}
+	if token, ok := s.cachedToken(installationID); ok {
+		return token, nil
+	}
+
+	token, err := s.shared(ctx, fmt.Sprintf("token/%d", installationID), func(ctx context.Context) (interface{}, error) {
+		// Another caller may have minted it while this one waited
+		if token, ok := s.cachedToken(installationID); ok {
+			return token, nil
+		}
+
+		var body struct {
+			Token     string    `json:"token"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}
+		path := fmt.Sprintf("/app/installations/%d/access_tokens", installationID)
+		if _, err := s.appRequest(ctx, http.MethodPost, path, &body); err != nil {
+			return "", fmt.Errorf("failed to create installation token for %s: %w", owner, err)
+		}
+
+		s.mu.Lock()
+		s.tokens[installationID] = installationToken{token: body.Token, expiresAt: body.ExpiresAt}
+		s.mu.Unlock()
+		return body.Token, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token.(string), nil
+}
+
+// InvalidateToken drops a token GitHub rejected, along with owner's
+// installation, so the next Token call looks the installation up again in
+// case the app was uninstalled or reinstalled
+func (s *AppTokenSource) InvalidateToken(owner, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, cached := range s.tokens {
+		if cached.token == token {
+			delete(s.tokens, id)
+		}
+	}
+	delete(s.installations, strings.ToLower(owner))
+}
+
+func (s *AppTokenSource) cachedToken(installationID int64) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cached, ok := s.tokens[installationID]
+	if !ok || time.Until(cached.expiresAt) <= installationTokenMargin {
+		return "", false
+	}
+	return cached.token, true
+}
+
+// installationFor returns the installation on owner's account, reloading the
+// app's installation list once when owner is not known yet
+func (s *AppTokenSource) installationFor(ctx context.Context, owner string) (int64, error) {
+	login := strings.ToLower(owner)
+	if id, ok := s.cachedInstallation(login); ok {
+		return id, nil
+	}
+
+	notInstalled := fmt.Errorf("%w: %w on %s", ErrNoToken, ErrAppNotInstalled, owner)
+	if s.recentMiss(login) {
+		return 0, notInstalled
+	}
+
+	if _, err := s.shared(ctx, "installations", func(ctx context.Context) (interface{}, error) {
+		return nil, s.loadInstallations(ctx)
+	}); err != nil {
+		return 0, err
+	}
+
+	if id, ok := s.cachedInstallation(login); ok {
+		return id, nil
+	}
+	s.mu.Lock()
+	s.misses[login] = time.Now()
+	s.mu.Unlock()
+	return 0, notInstalled
+}
+
+// recentMiss reports whether login had no installation when the
+// installations were last listed, less than installationMissTTL ago
+func (s *AppTokenSource) recentMiss(login string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	missedAt, ok := s.misses[login]
+	return ok && time.Since(missedAt) < installationMissTTL
+}
+
+// shared runs fn once for all callers asking for key at the same time. fn
+// gets a context of its own, so one caller giving up doesn't fail the others
+// waiting on it, and each caller stops waiting when its own context ends.
+func (s *AppTokenSource) shared(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	ch := s.group.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), appCallTimeout)
+		defer cancel()
+		return fn(ctx)
+	})
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *AppTokenSource) cachedInstallation(login string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.installations[login]
+	return id, ok
+}
+
+// loadInstallations lists every installation of the app, following the Link header
+func (s *AppTokenSource) loadInstallations(ctx context.Context) error {
+	found := map[string]int64{}
+	next := "/app/installations?per_page=100"
+	for page := 0; next != "" && page < maxInstallationPages; page++ {
+		var installations []struct {
+			ID      int64 `json:"id"`
+			Account struct {
+				Login string `json:"login"`
+			} `json:"account"`
+		}
+		header, err := s.appRequest(ctx, http.MethodGet, next, &installations)
+		if err != nil {
+			return fmt.Errorf("failed to list app installations: %w", err)
+		}
+		for _, inst := range installations {
+			found[strings.ToLower(inst.Account.Login)] = inst.ID
+		}
+		next = nextPageURL(header)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for login, id := range found {
+		s.installations[login] = id
+		delete(s.misses, login)
+	}
+	return nil
+}
+
+// appRequest calls an /app endpoint, or a next page URL, authenticated with a
+// freshly signed app JWT and returns the response headers
+func (s *AppTokenSource) appRequest(ctx context.Context, method, path string, out interface{}) (http.Header, error) {
+	appJWT, err := s.signAppJWT()
+	if err != nil {
+		return nil, err
+	}
+
+	url := path
+	if !strings.HasPrefix(path, "http") {
+		url = s.baseURL + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+appJWT)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: errorMessage(bodyBytes)}
+	}
+	return resp.Header, json.Unmarshal(bodyBytes, out)
+}
+
+// signAppJWT creates the short lived RS256 JWT identifying the app itself.
+// iat is backdated a minute to allow for clock drift, as GitHub recommends.
+func (s *AppTokenSource) signAppJWT() (string, error) {
+	now := time.Now()
+	claims := jwtgo.StandardClaims{
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(appJWTLifetime).Unix(),
+		Issuer:    fmt.Sprintf("%d", s.appID),
+	}
+	return jwtgo.NewWithClaims(jwtgo.SigningMethodRS256, claims).SignedString(s.key)
+}
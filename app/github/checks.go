@@ -0,0 +1,77 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pr-mail/app/domain"
+	"pr-mail/app/dto"
+	"sort"
+)
+
+func (c *gitHubClientImpl) FetchCIStatus(ctx context.Context, owner, repo, sha string) (*dto.GitHubCIData, error) {
+	var data dto.GitHubCIData
+
+	path := fmt.Sprintf("/repos/%s/%s/commits/%s/status", owner, repo, sha)
+	_, bodyBytes, err := c.get(ctx, owner, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bodyBytes, &data.Status); err != nil {
+		return nil, fmt.Errorf("failed to decode GitHub commit status response: %w", err)
+	}
+
+	// Every page is read, a failing run on a later page must not be missed
+	path = fmt.Sprintf("/repos/%s/%s/commits/%s/check-runs?per_page=100", owner, repo, sha)
+	err = c.getPages(ctx, owner, path, 0, func(body []byte) (bool, error) {
+		var runs dto.GitHubCheckRuns
+		if err := json.Unmarshal(body, &runs); err != nil {
+			return false, fmt.Errorf("failed to decode GitHub check runs response: %w", err)
+		}
+		data.CheckRuns = append(data.CheckRuns, runs.CheckRuns...)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// CIStatus summarizes commit statuses and check runs into one of the domain.CI*
+// states, along with the names of the failing checks
+func CIStatus(data *dto.GitHubCIData) (state string, failing []string) {
+	pending, total := false, 0
+
+	for _, status := range data.Status.Statuses {
+		total++
+		switch status.State {
+		case "failure", "error":
+			failing = append(failing, status.Context)
+		case "pending":
+			pending = true
+		}
+	}
+	for _, run := range data.CheckRuns {
+		total++
+		if run.Status != "completed" {
+			pending = true
+			continue
+		}
+		switch run.Conclusion {
+		case "failure", "timed_out", "cancelled", "action_required", "startup_failure":
+			failing = append(failing, run.Name)
+		}
+	}
+	sort.Strings(failing)
+
+	switch {
+	case len(failing) > 0:
+		return domain.CIFailure, failing
+	case pending:
+		return domain.CIPending, nil
+	case total > 0:
+		return domain.CISuccess, nil
+	}
+	return domain.CINone, nil
+}
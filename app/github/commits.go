@@ -0,0 +1,29 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pr-mail/app/dto"
+)
+
+// MaxPRCommits is the most commits GitHub will list for a single pull request
+const MaxPRCommits = 250
+
+func (c *gitHubClientImpl) FetchPRCommits(ctx context.Context, owner, repo string, prNumber int) ([]dto.GitHubCommit, error) {
+	var commits []dto.GitHubCommit
+	path := fmt.Sprintf("/repos/%s/%s/pulls/%d/commits?per_page=100", owner, repo, prNumber)
+
+	err := c.getPages(ctx, owner, path, (MaxPRCommits+99)/100, func(body []byte) (bool, error) {
+		var page []dto.GitHubCommit
+		if err := json.Unmarshal(body, &page); err != nil {
+			return false, fmt.Errorf("failed to decode GitHub commits response: %w", err)
+		}
+		commits = append(commits, page...)
+		return len(page) > 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return commits, nil
+}
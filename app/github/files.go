@@ -0,0 +1,32 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pr-mail/app/dto"
+)
+
+// MaxPRFiles is the most files GitHub will list for a single pull request
+const MaxPRFiles = 3000
+
+// FetchPRFiles lists the files changed by the PR. GitHub stops listing at
+// MaxPRFiles, so callers compare the count with the PR's changed_files to tell
+// whether the list is complete.
+func (c *gitHubClientImpl) FetchPRFiles(ctx context.Context, owner, repo string, prNumber int) ([]dto.GitHubPRFile, error) {
+	path := fmt.Sprintf("/repos/%s/%s/pulls/%d/files?per_page=100", owner, repo, prNumber)
+
+	var files []dto.GitHubPRFile
+	err := c.getPages(ctx, owner, path, MaxPRFiles/100, func(body []byte) (bool, error) {
+		var page []dto.GitHubPRFile
+		if err := json.Unmarshal(body, &page); err != nil {
+			return false, fmt.Errorf("failed to decode GitHub files response: %w", err)
+		}
+		files = append(files, page...)
+		return len(page) > 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
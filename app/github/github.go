@@ -1,50 +1,395 @@
 package github
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"pr-mail/app/domain"
 	"pr-mail/app/dto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
-// func FetchPRDetails(url string) (*dto.GitHubPRResponse, error) {
-func FetchPRDetails(owner, repo string, prNumber int) (*dto.GitHubPRResponse, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d", owner, repo, prNumber)
+const (
+	// DefaultBaseURL is the public GitHub REST API endpoint
+	DefaultBaseURL = "https://api.github.com"
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+	// DefaultTimeout is applied to every GitHub request when no timeout is configured
+	DefaultTimeout = 30 * time.Second
+)
+
+// GitHubClient fetches pull request data from a GitHub (or GitHub Enterprise) API
+type GitHubClient interface {
+	FetchPRDetails(ctx context.Context, owner, repo string, prNumber int) (*dto.GitHubPRResponse, error)
+	// FetchPRDetailsIfChanged sends the stored validators and returns ErrNotModified on 304
+	FetchPRDetailsIfChanged(ctx context.Context, owner, repo string, prNumber int, cache CacheValidators) (*dto.GitHubPRResponse, error)
+	FetchPRReviews(ctx context.Context, owner, repo string, prNumber int) (*dto.GitHubReviewData, error)
+	// FetchPRReviewsIfChanged sends the stored validators and returns ErrNotModified when nothing changed
+	FetchPRReviewsIfChanged(ctx context.Context, owner, repo string, prNumber int, cache ReviewValidators) (*dto.GitHubReviewData, ReviewValidators, error)
+	FetchCIStatus(ctx context.Context, owner, repo, sha string) (*dto.GitHubCIData, error)
+	FetchPRFiles(ctx context.Context, owner, repo string, prNumber int) ([]dto.GitHubPRFile, error)
+	FetchPRCommits(ctx context.Context, owner, repo string, prNumber int) ([]dto.GitHubCommit, error)
+	SearchOpenPRs(ctx context.Context, org, login string) ([]dto.GitHubSearchPR, error)
+	// WebHost is the host, with any path prefix, of the PR links this client can fetch
+	WebHost() string
+}
+
+// ErrNotModified is returned by conditional fetches when GitHub answers 304
+var ErrNotModified = errors.New("github resource not modified")
+
+// CacheValidators are the ETag/Last-Modified values of a previously fetched response
+type CacheValidators struct {
+	ETag         string
+	LastModified string
+}
+
+func (v CacheValidators) IsZero() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// TokenSource supplies the token sent in the Authorization header. owner is
+// the user or organization owning the repository being requested.
+type TokenSource interface {
+	Token(ctx context.Context, owner string) (string, error)
+}
+
+// ErrNoToken is returned by a TokenSource that has no token to give
+var ErrNoToken = errors.New("github token not configured")
+
+// StaticToken is a TokenSource that always returns the same token
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context, owner string) (string, error) {
+	if t == "" {
+		return "", ErrNoToken
 	}
+	return string(t), nil
+}
+
+// EnvToken is a TokenSource that reads the token from the named environment variable on every call
+type EnvToken string
 
-	// gitHub Token from .env
-	token := os.Getenv("GITHUB_TOKEN")
+func (t EnvToken) Token(ctx context.Context, owner string) (string, error) {
+	token := os.Getenv(string(t))
 	if token == "" {
-		return nil, fmt.Errorf("GITHUB_TOKEN not set in environment")
+		return "", fmt.Errorf("%w: %s not set in environment", ErrNoToken, string(t))
+	}
+	return token, nil
+}
+
+type Config struct {
+	// BaseURL of the API, e.g. https://api.github.com or https://ghe.example.com/api/v3
+	BaseURL string
+	// Tokens supplies the bearer token for each request
+	Tokens TokenSource
+	// Timeout for a single request, DefaultTimeout when zero
+	Timeout time.Duration
+	// Transport used by the underlying http.Client, http.DefaultTransport when nil
+	Transport http.RoundTripper
+	// MaxRetries for secondary rate limits, DefaultMaxRetries when zero
+	MaxRetries int
+	// RetryBackoff is the base delay for retries without Retry-After, DefaultRetryBackoff when zero
+	RetryBackoff time.Duration
+	// Mode is ModeREST (default) or ModeGraphQL to batch PR lookups
+	Mode string
+}
+
+// ConfigFromEnv builds a Config from GITHUB_API_URL, GITHUB_TOKEN, GITHUB_TIMEOUT, GITHUB_MAX_RETRIES and GITHUB_API_MODE.
+// GITHUB_TOKEN may list several comma separated tokens, and GITHUB_OWNER_TOKENS
+// ("owner:token1,token2;other:token3") adds tokens for specific users or orgs.
+// When GITHUB_APP_ID is set the client authenticates as that GitHub App, using the
+// key in GITHUB_APP_PRIVATE_KEY or GITHUB_APP_PRIVATE_KEY_PATH, for owners that
+// installed it and with the tokens above for the others.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		BaseURL: os.Getenv("GITHUB_API_URL"),
+		Tokens:  EnvToken("GITHUB_TOKEN"),
+	}
+	if ownerTokens, token := os.Getenv("GITHUB_OWNER_TOKENS"), os.Getenv("GITHUB_TOKEN"); ownerTokens != "" || strings.Contains(token, ",") {
+		registry, err := ParseOwnerTokens(ownerTokens, strings.Split(token, ","))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid GITHUB_OWNER_TOKENS")
+		}
+		cfg.Tokens = registry
+	}
+	if appID := os.Getenv("GITHUB_APP_ID"); appID != "" {
+		source, err := appTokenSourceFromEnv(appID, cfg.BaseURL)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid GitHub App configuration")
+		}
+		cfg.Tokens = NewAppWithFallback(source, cfg.Tokens)
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("GITHUB_TIMEOUT")); err == nil {
+		cfg.Timeout = timeout
+	}
+	if retries, err := strconv.Atoi(os.Getenv("GITHUB_MAX_RETRIES")); err == nil {
+		cfg.MaxRetries = retries
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/vnd.github+json")
+	cfg.Mode = strings.ToLower(os.Getenv("GITHUB_API_MODE"))
+	return cfg
+}
 
-	//
-	resp, err := http.DefaultClient.Do(req)
+func appTokenSourceFromEnv(appID, baseURL string) (*AppTokenSource, error) {
+	id, err := strconv.ParseInt(appID, 10, 64)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("GITHUB_APP_ID must be numeric: %w", err)
+	}
+
+	pem := []byte(os.Getenv("GITHUB_APP_PRIVATE_KEY"))
+	if path := os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH"); len(pem) == 0 && path != "" {
+		if pem, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read GitHub App private key: %w", err)
+		}
+	}
+	if len(pem) == 0 {
+		return nil, fmt.Errorf("GITHUB_APP_PRIVATE_KEY or GITHUB_APP_PRIVATE_KEY_PATH must be set")
+	}
+
+	key, err := ParseAppPrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App private key: %w", err)
+	}
+	return NewAppTokenSource(id, key, baseURL, nil), nil
+}
+
+type gitHubClientImpl struct {
+	baseURL      string
+	tokens       TokenSource
+	http         *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+func NewGitHubClient(cfg Config) GitHubClient {
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	tokens := cfg.Tokens
+	if tokens == nil {
+		tokens = EnvToken("GITHUB_TOKEN")
 	}
-	defer resp.Body.Close()
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+	retryBackoff := cfg.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = DefaultRetryBackoff
+	}
+
+	client := &gitHubClientImpl{
+		baseURL: baseURL,
+		tokens:  tokens,
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: cfg.Transport,
+		},
+		maxRetries:   maxRetries,
+		retryBackoff: retryBackoff,
+	}
+	if cfg.Mode == ModeGraphQL {
+		return newGraphQLClient(client)
+	}
+	return client
+}
 
-	//Log response body for debugging
-	bodyBytes, err := io.ReadAll(resp.Body)
+func (c *gitHubClientImpl) WebHost() string {
+	return WebHost(c.baseURL)
+}
+
+// WebHost derives the web host PR links use from an API base URL:
+// github.com for the public API, the server's host and any path prefix it is
+// served under for GitHub Enterprise, e.g. ghe.example.com for
+// https://ghe.example.com/api/v3
+func WebHost(baseURL string) string {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		return "github.com"
+	}
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "https://" + baseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return "github.com"
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	if host == "api.github.com" {
+		return "github.com"
+	}
+	path := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/v3"), "/api")
+	return host + strings.TrimRight(path, "/")
+}
+
+func (c *gitHubClientImpl) FetchPRDetails(ctx context.Context, owner, repo string, prNumber int) (*dto.GitHubPRResponse, error) {
+	return c.FetchPRDetailsIfChanged(ctx, owner, repo, prNumber, CacheValidators{})
+}
+
+func (c *gitHubClientImpl) FetchPRDetailsIfChanged(ctx context.Context, owner, repo string, prNumber int, cache CacheValidators) (*dto.GitHubPRResponse, error) {
+	path := fmt.Sprintf("/repos/%s/%s/pulls/%d", owner, repo, prNumber)
+
+	header := http.Header{}
+	if cache.ETag != "" {
+		header.Set("If-None-Match", cache.ETag)
+	}
+	if cache.LastModified != "" {
+		header.Set("If-Modified-Since", cache.LastModified)
+	}
+
+	resp, bodyBytes, err := c.get(ctx, owner, path, header)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("Raw GitHub API Response:\n", string(bodyBytes))
 
 	// Unmarshal into struct
 	var data dto.GitHubPRResponse
 	if err := json.Unmarshal(bodyBytes, &data); err != nil {
 		return nil, fmt.Errorf("failed to decode GitHub API response: %w", err)
 	}
+	data.ETag = resp.Header.Get("ETag")
+	data.LastModified = resp.Header.Get("Last-Modified")
 
 	return &data, nil
 }
+
+// get performs an authenticated GET against path, retrying secondary rate limits.
+// A 304 is returned as ErrNotModified, any other non-2xx response as *APIError or *RateLimitError.
+func (c *gitHubClientImpl) get(ctx context.Context, owner, path string, header http.Header) (*http.Response, []byte, error) {
+	return c.do(ctx, http.MethodGet, owner, path, header, nil)
+}
+
+// do sends an authenticated request using owner's token, see get for how responses are handled
+func (c *gitHubClientImpl) do(ctx context.Context, method, owner, path string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	url := path
+	if !strings.HasPrefix(path, "http") {
+		url = c.baseURL + path
+	}
+
+	token, err := c.tokens.Token(ctx, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Secondary limit retries and token rotations have separate budgets, a
+	// rotation doesn't use up a retry and rotations stop once every token was tried
+	retries, rotations := 0, 0
+	reauthenticated := false
+	for {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+		if err != nil {
+			return nil, nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		bodyBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, bodyBytes, nil
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			return resp, nil, ErrNotModified
+		}
+
+		if invalidator, ok := c.tokens.(TokenInvalidator); ok && resp.StatusCode == http.StatusUnauthorized && !reauthenticated {
+			// A cached token was revoked or the app uninstalled, try once more with a fresh one
+			invalidator.InvalidateToken(owner, token)
+			if token, err = c.tokens.Token(ctx, owner); err != nil {
+				return nil, nil, err
+			}
+			reauthenticated = true
+			continue
+		}
+
+		message := errorMessage(bodyBytes)
+		if !isRateLimited(resp, bodyBytes) {
+			return nil, nil, &APIError{StatusCode: resp.StatusCode, Message: message}
+		}
+
+		delay, retry := retryDelay(resp, retries, c.retryBackoff, time.Now())
+		if !retry {
+			// The token's budget is spent, switch to another token for this owner if there is one
+			rlErr := rateLimitError(resp, message)
+			reporter, ok := c.tokens.(RateLimitReporter)
+			if !ok {
+				return nil, nil, rlErr
+			}
+			reporter.MarkRateLimited(owner, token, rlErr.ResetAt)
+			if rotations++; rotations >= reporter.PoolSize(owner) {
+				return nil, nil, rlErr
+			}
+			next, err := c.tokens.Token(ctx, owner)
+			if err != nil {
+				return nil, nil, err
+			}
+			log.Warn().Msgf("GitHub token for %s exhausted, rotating to the next one", owner)
+			token = next
+			continue
+		}
+		if retries >= c.maxRetries {
+			return nil, nil, rateLimitError(resp, message)
+		}
+		retries++
+		log.Warn().Msgf("GitHub rate limited %s, retrying in %s (attempt %d/%d)", path, delay, retries, c.maxRetries)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// errorMessage extracts the "message" field GitHub puts in error bodies
+func errorMessage(body []byte) string {
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Message != "" {
+		return payload.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// PRStatus derives the canonical domain.PRStatus* value from a GitHub response,
+// which reports merged PRs as "closed" and drafts as "open"
+func PRStatus(data *dto.GitHubPRResponse) string {
+	switch {
+	case data.Merged || data.MergedAt != nil:
+		return domain.PRStatusMerged
+	case data.State == "closed":
+		return domain.PRStatusClosed
+	case data.Draft:
+		return domain.PRStatusDraft
+	}
+	return domain.PRStatusOpen
+}
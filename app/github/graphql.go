@@ -0,0 +1,400 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pr-mail/app/dto"
+	"strings"
+	"time"
+)
+
+const (
+	// ModeREST fetches every PR with individual REST calls
+	ModeREST = "rest"
+
+	// ModeGraphQL batches PR, review and CI lookups into GraphQL queries
+	ModeGraphQL = "graphql"
+
+	// graphQLBatchSize keeps each query well below GitHub's node limits
+	graphQLBatchSize = 20
+
+	// graphQLPageSize is how many files, commits, reviews and checks one query asks for
+	graphQLPageSize = 100
+)
+
+// BatchRef identifies one pull request in a batch fetch
+type BatchRef struct {
+	Owner  string
+	Repo   string
+	Number int
+}
+
+// BatchResult is everything fetched for one BatchRef. Reviews, CI, Files and
+// Commits are nil when the PR has more than one query's worth, so the caller
+// fetches them via REST.
+type BatchResult struct {
+	PR      *dto.GitHubPRResponse
+	Reviews *dto.GitHubReviewData
+	CI      *dto.GitHubCIData
+	Files   []dto.GitHubPRFile
+	Commits []dto.GitHubCommit
+	Err     error
+}
+
+// BatchFetcher is implemented by clients able to fetch many PRs per round-trip
+type BatchFetcher interface {
+	FetchPRBatch(ctx context.Context, refs []BatchRef) ([]BatchResult, error)
+}
+
+// graphQLClientImpl answers batch fetches through the GraphQL API and falls
+// back to the embedded REST client for everything else
+type graphQLClientImpl struct {
+	*gitHubClientImpl
+	endpoint string
+}
+
+func newGraphQLClient(rest *gitHubClientImpl) *graphQLClientImpl {
+	// GitHub Enterprise serves REST at /api/v3 and GraphQL at /api/graphql
+	endpoint := rest.baseURL + "/graphql"
+	if strings.HasSuffix(rest.baseURL, "/api/v3") {
+		endpoint = strings.TrimSuffix(rest.baseURL, "/v3") + "/graphql"
+	}
+	return &graphQLClientImpl{gitHubClientImpl: rest, endpoint: endpoint}
+}
+
+// FetchPRBatch returns one result per ref, in the same order. Refs are grouped
+// by owner so each query is sent with that owner's token. When a query fails
+// the error is set on the rest of that owner's refs and the other owners are
+// still fetched.
+func (c *graphQLClientImpl) FetchPRBatch(ctx context.Context, refs []BatchRef) ([]BatchResult, error) {
+	results := make([]BatchResult, len(refs))
+
+	byOwner := map[string][]int{}
+	var owners []string
+	for i, ref := range refs {
+		key := strings.ToLower(ref.Owner)
+		if _, ok := byOwner[key]; !ok {
+			owners = append(owners, key)
+		}
+		byOwner[key] = append(byOwner[key], i)
+	}
+
+	for _, owner := range owners {
+		indexes := byOwner[owner]
+		for start := 0; start < len(indexes); start += graphQLBatchSize {
+			end := min(start+graphQLBatchSize, len(indexes))
+			if err := c.queryBatch(ctx, refs, indexes[start:end], results); err != nil {
+				for _, i := range indexes[start:] {
+					results[i].Err = err
+				}
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+const graphQLPRFields = `
+fragment PRFields on PullRequest {
+  title body state isDraft merged mergedAt closedAt
+  additions deletions changedFiles
+  baseRefName headRefName headRefOid
+  commits(first: 100) {
+    totalCount
+    nodes { commit { oid message authoredDate author { name email user { login } } } }
+  }
+  files(first: 100) {
+    totalCount
+    nodes { path changeType additions deletions }
+  }
+  reviews(first: 100) {
+    totalCount
+    nodes { author { login } state submittedAt }
+  }
+  reviewRequests(first: 100) {
+    totalCount
+    nodes { requestedReviewer { __typename ... on User { login } ... on Team { slug } } }
+  }
+  lastCommit: commits(last: 1) {
+    nodes { commit { statusCheckRollup { contexts(first: 100) {
+      totalCount
+      nodes {
+        __typename
+        ... on CheckRun { name status conclusion }
+        ... on StatusContext { context state }
+      }
+    } } } }
+  }
+}`
+
+type graphQLPR struct {
+	Title        string     `json:"title"`
+	Body         string     `json:"body"`
+	State        string     `json:"state"` // OPEN, CLOSED, MERGED
+	IsDraft      bool       `json:"isDraft"`
+	Merged       bool       `json:"merged"`
+	MergedAt     *time.Time `json:"mergedAt"`
+	ClosedAt     *time.Time `json:"closedAt"`
+	Additions    int        `json:"additions"`
+	Deletions    int        `json:"deletions"`
+	ChangedFiles int        `json:"changedFiles"`
+	BaseRefName  string     `json:"baseRefName"`
+	HeadRefName  string     `json:"headRefName"`
+	HeadRefOid   string     `json:"headRefOid"`
+	Commits      struct {
+		TotalCount int `json:"totalCount"`
+		Nodes      []struct {
+			Commit struct {
+				Oid          string    `json:"oid"`
+				Message      string    `json:"message"`
+				AuthoredDate time.Time `json:"authoredDate"`
+				Author       struct {
+					Name  string          `json:"name"`
+					Email string          `json:"email"`
+					User  *dto.GitHubUser `json:"user"`
+				} `json:"author"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"commits"`
+	Files struct {
+		TotalCount int `json:"totalCount"`
+		Nodes      []struct {
+			Path       string `json:"path"`
+			ChangeType string `json:"changeType"`
+			Additions  int    `json:"additions"`
+			Deletions  int    `json:"deletions"`
+		} `json:"nodes"`
+	} `json:"files"`
+	Reviews struct {
+		TotalCount int `json:"totalCount"`
+		Nodes      []struct {
+			Author      *dto.GitHubUser `json:"author"`
+			State       string          `json:"state"`
+			SubmittedAt *time.Time      `json:"submittedAt"`
+		} `json:"nodes"`
+	} `json:"reviews"`
+	ReviewRequests struct {
+		TotalCount int `json:"totalCount"`
+		Nodes      []struct {
+			RequestedReviewer *struct {
+				Typename string `json:"__typename"`
+				Login    string `json:"login"`
+				Slug     string `json:"slug"`
+			} `json:"requestedReviewer"`
+		} `json:"nodes"`
+	} `json:"reviewRequests"`
+	LastCommit struct {
+		Nodes []struct {
+			Commit struct {
+				StatusCheckRollup *struct {
+					Contexts struct {
+						TotalCount int `json:"totalCount"`
+						Nodes      []struct {
+							Typename   string `json:"__typename"`
+							Name       string `json:"name"`
+							Status     string `json:"status"`
+							Conclusion string `json:"conclusion"`
+							Context    string `json:"context"`
+							State      string `json:"state"`
+						} `json:"nodes"`
+					} `json:"contexts"`
+				} `json:"statusCheckRollup"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"lastCommit"`
+}
+
+type graphQLError struct {
+	Type    string        `json:"type"`
+	Path    []interface{} `json:"path"`
+	Message string        `json:"message"`
+}
+
+// queryBatch fetches refs[indexes] in a single query, writing into results
+func (c *graphQLClientImpl) queryBatch(ctx context.Context, refs []BatchRef, indexes []int, results []BatchResult) error {
+	var sb strings.Builder
+	sb.WriteString("query(")
+	variables := map[string]interface{}{}
+	for n, i := range indexes {
+		if n > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "$o%d: String!, $r%d: String!, $n%d: Int!", n, n, n)
+		variables[fmt.Sprintf("o%d", n)] = refs[i].Owner
+		variables[fmt.Sprintf("r%d", n)] = refs[i].Repo
+		variables[fmt.Sprintf("n%d", n)] = refs[i].Number
+	}
+	sb.WriteString(") {\n")
+	for n := range indexes {
+		fmt.Fprintf(&sb, "  pr%d: repository(owner: $o%d, name: $r%d) { pullRequest(number: $n%d) { ...PRFields } }\n", n, n, n, n)
+	}
+	sb.WriteString("}\n")
+	sb.WriteString(graphQLPRFields)
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"query":     sb.String(),
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, bodyBytes, err := c.do(ctx, http.MethodPost, refs[indexes[0]].Owner, c.endpoint, nil, payload)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Data map[string]*struct {
+			PullRequest *graphQLPR `json:"pullRequest"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
+		return fmt.Errorf("failed to decode GitHub GraphQL response: %w", err)
+	}
+
+	// Errors are reported per alias, except rate limiting which fails the whole query
+	aliasErrs := map[string]error{}
+	for _, gqlErr := range resp.Errors {
+		if gqlErr.Type == "RATE_LIMITED" {
+			return &RateLimitError{Message: gqlErr.Message}
+		}
+		alias := ""
+		if len(gqlErr.Path) > 0 {
+			alias, _ = gqlErr.Path[0].(string)
+		}
+		status := http.StatusBadGateway
+		if gqlErr.Type == "NOT_FOUND" {
+			status = http.StatusNotFound
+		} else if gqlErr.Type == "FORBIDDEN" {
+			status = http.StatusForbidden
+		}
+		aliasErrs[alias] = &APIError{StatusCode: status, Message: gqlErr.Message}
+	}
+
+	for n, i := range indexes {
+		alias := fmt.Sprintf("pr%d", n)
+		repo := resp.Data[alias]
+		switch {
+		case aliasErrs[alias] != nil:
+			results[i].Err = aliasErrs[alias]
+		case repo == nil || repo.PullRequest == nil:
+			results[i].Err = &APIError{StatusCode: http.StatusNotFound, Message: "pull request not found"}
+		default:
+			results[i] = toBatchResult(repo.PullRequest)
+		}
+	}
+	return nil
+}
+
+// toBatchResult maps the GraphQL shape onto the same dto types the REST client returns
+func toBatchResult(pr *graphQLPR) BatchResult {
+	data := &dto.GitHubPRResponse{
+		Title:        pr.Title,
+		Body:         pr.Body,
+		State:        "open",
+		Draft:        pr.IsDraft,
+		Merged:       pr.Merged,
+		MergedAt:     pr.MergedAt,
+		ClosedAt:     pr.ClosedAt,
+		ChangedFiles: pr.ChangedFiles,
+		Additions:    pr.Additions,
+		Deletions:    pr.Deletions,
+		Commits:      pr.Commits.TotalCount,
+	}
+	if pr.State != "OPEN" {
+		data.State = "closed"
+	}
+	data.Base.Ref = pr.BaseRefName
+	data.Head.Ref = pr.HeadRefName
+	data.Head.SHA = pr.HeadRefOid
+
+	result := BatchResult{PR: data}
+
+	if pr.Reviews.TotalCount <= graphQLPageSize && pr.ReviewRequests.TotalCount <= graphQLPageSize {
+		result.Reviews = &dto.GitHubReviewData{}
+		for _, r := range pr.Reviews.Nodes {
+			review := dto.GitHubReview{State: r.State, SubmittedAt: r.SubmittedAt}
+			if r.Author != nil {
+				review.User = *r.Author
+			}
+			result.Reviews.Reviews = append(result.Reviews.Reviews, review)
+		}
+		for _, req := range pr.ReviewRequests.Nodes {
+			if req.RequestedReviewer == nil {
+				continue
+			}
+			if req.RequestedReviewer.Typename == "Team" {
+				result.Reviews.Requested.Teams = append(result.Reviews.Requested.Teams, dto.GitHubTeam{Slug: req.RequestedReviewer.Slug})
+			} else {
+				result.Reviews.Requested.Users = append(result.Reviews.Requested.Users, dto.GitHubUser{Login: req.RequestedReviewer.Login})
+			}
+		}
+	}
+
+	ci := &dto.GitHubCIData{}
+	for _, node := range pr.LastCommit.Nodes {
+		if node.Commit.StatusCheckRollup == nil {
+			continue
+		}
+		if node.Commit.StatusCheckRollup.Contexts.TotalCount > graphQLPageSize {
+			ci = nil
+			break
+		}
+		for _, ctx := range node.Commit.StatusCheckRollup.Contexts.Nodes {
+			if ctx.Typename == "StatusContext" {
+				state := strings.ToLower(ctx.State)
+				if state == "expected" {
+					state = "pending"
+				}
+				ci.Status.Statuses = append(ci.Status.Statuses, dto.GitHubCommitStatus{
+					Context: ctx.Context,
+					State:   state,
+				})
+				continue
+			}
+			ci.CheckRuns = append(ci.CheckRuns, dto.GitHubCheckRun{
+				Name:       ctx.Name,
+				Status:     strings.ToLower(ctx.Status),
+				Conclusion: strings.ToLower(ctx.Conclusion),
+			})
+		}
+	}
+	result.CI = ci
+
+	if pr.Files.TotalCount <= graphQLPageSize {
+		result.Files = []dto.GitHubPRFile{}
+		for _, f := range pr.Files.Nodes {
+			status := strings.ToLower(f.ChangeType)
+			if status == "deleted" {
+				status = "removed"
+			}
+			result.Files = append(result.Files, dto.GitHubPRFile{
+				Filename:  f.Path,
+				Status:    status,
+				Additions: f.Additions,
+				Deletions: f.Deletions,
+				Changes:   f.Additions + f.Deletions,
+			})
+		}
+	}
+
+	if pr.Commits.TotalCount <= graphQLPageSize {
+		result.Commits = []dto.GitHubCommit{}
+		for _, node := range pr.Commits.Nodes {
+			var commit dto.GitHubCommit
+			commit.SHA = node.Commit.Oid
+			commit.Commit.Message = node.Commit.Message
+			commit.Commit.Author.Name = node.Commit.Author.Name
+			commit.Commit.Author.Email = node.Commit.Author.Email
+			commit.Commit.Author.Date = node.Commit.AuthoredDate
+			commit.Author = node.Commit.Author.User
+			result.Commits = append(result.Commits, commit)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,46 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// getPages follows GitHub's Link header from path, handing each page body to
+// visit until there is no next page, visit returns false or maxPages is reached.
+// maxPages of zero means no limit.
+func (c *gitHubClientImpl) getPages(ctx context.Context, owner, path string, maxPages int, visit func(body []byte) (bool, error)) error {
+	next := path
+	for page := 1; next != ""; page++ {
+		resp, bodyBytes, err := c.get(ctx, owner, next, nil)
+		if err != nil {
+			return err
+		}
+		more, err := visit(bodyBytes)
+		if err != nil {
+			return err
+		}
+		if !more || (maxPages > 0 && page >= maxPages) {
+			return nil
+		}
+		next = nextPageURL(resp.Header)
+	}
+	return nil
+}
+
+// nextPageURL extracts the rel="next" target from a Link header such as
+// <https://api.github.com/...&page=2>; rel="next", <...>; rel="last"
+func nextPageURL(h http.Header) string {
+	for _, link := range strings.Split(h.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is how many times a secondary rate limited request is retried
+	DefaultMaxRetries = 3
+
+	// DefaultRetryBackoff is the base delay used when GitHub gives no Retry-After
+	DefaultRetryBackoff = 2 * time.Second
+
+	// maxRetryWait caps a single wait so a generate click never hangs for minutes
+	maxRetryWait = 60 * time.Second
+)
+
+// ErrRateLimited is matched by errors.Is for any RateLimitError
+var ErrRateLimited = errors.New("github rate limit exceeded")
+
+// RateLimitError is returned when the token's request budget is exhausted
+// or a secondary rate limit persisted through all retries
+type RateLimitError struct {
+	Remaining int
+	ResetAt   time.Time
+	Message   string
+}
+
+func (e *RateLimitError) Error() string {
+	if e.ResetAt.IsZero() {
+		return fmt.Sprintf("github rate limit exceeded: %s", e.Message)
+	}
+	return fmt.Sprintf("github rate limit exceeded, resets at %s: %s", e.ResetAt.Format(time.RFC3339), e.Message)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// APIError is returned for any other non-2xx GitHub response
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("github api returned %d: %s", e.StatusCode, e.Message)
+}
+
+// RateLimit is the budget GitHub reported on the last response
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	ResetAt   time.Time
+}
+
+// parseRateLimit reads the X-RateLimit-* headers, ok is false when they are absent
+func parseRateLimit(h http.Header) (rl RateLimit, ok bool) {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return rl, false
+	}
+	rl.Remaining = remaining
+	rl.Limit, _ = strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		rl.ResetAt = time.Unix(reset, 0)
+	}
+	return rl, true
+}
+
+// parseRetryAfter reads Retry-After as either delay-seconds or an HTTP date
+func parseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// isRateLimited reports whether a response is a primary or secondary rate limit
+func isRateLimited(resp *http.Response, body []byte) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	if resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(string(body)), "rate limit")
+}
+
+// retryDelay decides how long to wait before retrying a rate limited response.
+// retry is false when the primary budget is exhausted, since waiting for the
+// hourly reset is not something a request should do.
+func retryDelay(resp *http.Response, attempt int, base time.Duration, now time.Time) (delay time.Duration, retry bool) {
+	if d, ok := parseRetryAfter(resp.Header, now); ok {
+		delay = d
+	} else if rl, ok := parseRateLimit(resp.Header); ok && rl.Remaining == 0 {
+		return 0, false
+	} else {
+		delay = base << attempt
+	}
+
+	// Add up to 50% jitter so concurrent callers don't retry in lockstep
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	}
+	if delay > maxRetryWait {
+		return 0, false
+	}
+	return delay, true
+}
+
+// rateLimitError builds the typed error for a response that can't be retried
+func rateLimitError(resp *http.Response, message string) *RateLimitError {
+	rlErr := &RateLimitError{Message: message}
+	if rl, ok := parseRateLimit(resp.Header); ok {
+		rlErr.Remaining = rl.Remaining
+		rlErr.ResetAt = rl.ResetAt
+	}
+	if d, ok := parseRetryAfter(resp.Header, time.Now()); ok && rlErr.ResetAt.IsZero() {
+		rlErr.ResetAt = time.Now().Add(d)
+	}
+	return rlErr
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
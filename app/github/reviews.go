@@ -0,0 +1,153 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pr-mail/app/domain"
+	"pr-mail/app/dto"
+	"sort"
+)
+
+// ReviewValidators are the ETags of a PR's reviews and requested reviewers.
+// Reviews is left empty when they took more than one page, as a new review on
+// a later page leaves the first page's ETag unchanged.
+type ReviewValidators struct {
+	Reviews   string
+	Requested string
+}
+
+func (c *gitHubClientImpl) FetchPRReviews(ctx context.Context, owner, repo string, prNumber int) (*dto.GitHubReviewData, error) {
+	data, _, err := c.FetchPRReviewsIfChanged(ctx, owner, repo, prNumber, ReviewValidators{})
+	return data, err
+}
+
+// FetchPRReviewsIfChanged returns ErrNotModified when GitHub answers 304 for
+// both the reviews and the requested reviewers. Reviews change without the PR's
+// own ETag changing, so they are checked even when the PR was not modified.
+// Conditional requests answered with 304 don't count against the rate limit.
+func (c *gitHubClientImpl) FetchPRReviewsIfChanged(ctx context.Context, owner, repo string, prNumber int, cache ReviewValidators) (*dto.GitHubReviewData, ReviewValidators, error) {
+	reviewsPath := fmt.Sprintf("/repos/%s/%s/pulls/%d/reviews?per_page=100", owner, repo, prNumber)
+	requestedPath := fmt.Sprintf("/repos/%s/%s/pulls/%d/requested_reviewers", owner, repo, prNumber)
+
+	if cache.Reviews != "" && cache.Requested != "" {
+		reviewsUnchanged, err := c.notModified(ctx, owner, reviewsPath, cache.Reviews)
+		if err != nil {
+			return nil, ReviewValidators{}, err
+		}
+		if reviewsUnchanged {
+			requestedUnchanged, err := c.notModified(ctx, owner, requestedPath, cache.Requested)
+			if err != nil {
+				return nil, ReviewValidators{}, err
+			}
+			if requestedUnchanged {
+				return nil, cache, ErrNotModified
+			}
+		}
+	}
+
+	var data dto.GitHubReviewData
+	var validators ReviewValidators
+	next := reviewsPath
+	for page := 1; next != ""; page++ {
+		resp, bodyBytes, err := c.get(ctx, owner, next, nil)
+		if err != nil {
+			return nil, ReviewValidators{}, err
+		}
+		var reviews []dto.GitHubReview
+		if err := json.Unmarshal(bodyBytes, &reviews); err != nil {
+			return nil, ReviewValidators{}, fmt.Errorf("failed to decode GitHub reviews response: %w", err)
+		}
+		data.Reviews = append(data.Reviews, reviews...)
+
+		next = nextPageURL(resp.Header)
+		if page == 1 && next == "" {
+			validators.Reviews = resp.Header.Get("ETag")
+		}
+		if len(reviews) == 0 {
+			break
+		}
+	}
+
+	resp, bodyBytes, err := c.get(ctx, owner, requestedPath, nil)
+	if err != nil {
+		return nil, ReviewValidators{}, err
+	}
+	if err := json.Unmarshal(bodyBytes, &data.Requested); err != nil {
+		return nil, ReviewValidators{}, fmt.Errorf("failed to decode GitHub requested reviewers response: %w", err)
+	}
+	validators.Requested = resp.Header.Get("ETag")
+
+	return &data, validators, nil
+}
+
+// notModified sends a conditional GET for path and reports whether GitHub answered 304
+func (c *gitHubClientImpl) notModified(ctx context.Context, owner, path, etag string) (bool, error) {
+	header := http.Header{}
+	header.Set("If-None-Match", etag)
+	_, _, err := c.get(ctx, owner, path, header)
+	if errors.Is(err, ErrNotModified) {
+		return true, nil
+	}
+	return false, err
+}
+
+// ReviewStatus aggregates reviews into one of the domain.Review* states and
+// returns the names of everyone who reviewed or is still requested.
+//
+// Each reviewer counts with their latest approving, rejecting or dismissed
+// review, so an approval after requested changes clears the block.
+func ReviewStatus(data *dto.GitHubReviewData) (status string, reviewers []string) {
+	latest := map[string]string{}
+	commented := false
+	for _, review := range data.Reviews {
+		login := review.User.Login
+		if _, seen := latest[login]; !seen {
+			latest[login] = ""
+			reviewers = append(reviewers, login)
+		}
+		switch review.State {
+		case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+			latest[login] = review.State
+		case "COMMENTED":
+			commented = true
+		}
+	}
+
+	pending := 0
+	for _, user := range data.Requested.Users {
+		pending++
+		if _, seen := latest[user.Login]; !seen {
+			reviewers = append(reviewers, user.Login)
+		}
+	}
+	for _, team := range data.Requested.Teams {
+		pending++
+		reviewers = append(reviewers, team.Slug)
+	}
+	sort.Strings(reviewers)
+
+	approved, changesRequested := false, false
+	for _, state := range latest {
+		switch state {
+		case "APPROVED":
+			approved = true
+		case "CHANGES_REQUESTED":
+			changesRequested = true
+		}
+	}
+
+	switch {
+	case changesRequested:
+		return domain.ReviewChangesRequested, reviewers
+	case pending > 0:
+		return domain.ReviewAwaiting, reviewers
+	case approved:
+		return domain.ReviewApproved, reviewers
+	case commented:
+		return domain.ReviewCommented, reviewers
+	}
+	return domain.ReviewAwaiting, reviewers
+}
@@ -0,0 +1,33 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"pr-mail/app/dto"
+)
+
+// MaxSearchResults is the most results the search API returns for one query
+const MaxSearchResults = 1000
+
+// SearchOpenPRs finds open pull requests authored by login in the org's repositories.
+// The org also selects the token, search has a separate and smaller rate limit.
+func (c *gitHubClientImpl) SearchOpenPRs(ctx context.Context, org, login string) ([]dto.GitHubSearchPR, error) {
+	query := fmt.Sprintf("is:pr is:open author:%s org:%s", login, org)
+	path := "/search/issues?per_page=100&q=" + url.QueryEscape(query)
+
+	var prs []dto.GitHubSearchPR
+	err := c.getPages(ctx, org, path, MaxSearchResults/100, func(body []byte) (bool, error) {
+		var page dto.GitHubSearchResponse
+		if err := json.Unmarshal(body, &page); err != nil {
+			return false, fmt.Errorf("failed to decode GitHub search response: %w", err)
+		}
+		prs = append(prs, page.Items...)
+		return len(page.Items) > 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prs, nil
+}
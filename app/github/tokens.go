@@ -0,0 +1,151 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitReporter is implemented by token sources that can switch to another
+// token once GitHub reports the current one's budget as exhausted
+type RateLimitReporter interface {
+	MarkRateLimited(owner, token string, resetAt time.Time)
+	// PoolSize is how many tokens there are for owner, which bounds rotations
+	PoolSize(owner string) int
+}
+
+// TokenInvalidator is implemented by token sources that cache tokens, so one
+// GitHub rejects as unauthorized can be dropped and a fresh one fetched
+type TokenInvalidator interface {
+	InvalidateToken(owner, token string)
+}
+
+type registryToken struct {
+	value        string
+	limitedUntil time.Time
+}
+
+// tokenPool is the set of tokens that can be used for one owner
+type tokenPool struct {
+	tokens []*registryToken
+	next   int
+}
+
+// TokenRegistry is a TokenSource holding several tokens per repository owner
+// (user or organization) plus a default pool for owners without their own.
+// Requests rotate round-robin through an owner's tokens, skipping any that are
+// rate limited until GitHub's reported reset time.
+type TokenRegistry struct {
+	mu       sync.Mutex
+	owners   map[string]*tokenPool // keyed by lowercased owner
+	fallback *tokenPool
+}
+
+func NewTokenRegistry(defaultTokens []string) *TokenRegistry {
+	return &TokenRegistry{
+		owners:   map[string]*tokenPool{},
+		fallback: newTokenPool(defaultTokens),
+	}
+}
+
+func newTokenPool(tokens []string) *tokenPool {
+	p := &tokenPool{}
+	for _, t := range tokens {
+		if t = strings.TrimSpace(t); t != "" {
+			p.tokens = append(p.tokens, &registryToken{value: t})
+		}
+	}
+	return p
+}
+
+// Add registers tokens used for repositories owned by owner
+func (r *TokenRegistry) Add(owner string, tokens ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strings.ToLower(owner)
+	if p, ok := r.owners[key]; ok {
+		p.tokens = append(p.tokens, newTokenPool(tokens).tokens...)
+		return
+	}
+	r.owners[key] = newTokenPool(tokens)
+}
+
+func (r *TokenRegistry) Token(ctx context.Context, owner string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := r.poolFor(owner)
+	if len(p.tokens) == 0 {
+		return "", fmt.Errorf("%w: no token configured for %s", ErrNoToken, owner)
+	}
+
+	now := time.Now()
+	var earliest time.Time
+	for i := 0; i < len(p.tokens); i++ {
+		t := p.tokens[(p.next+i)%len(p.tokens)]
+		if now.After(t.limitedUntil) {
+			p.next = (p.next + i + 1) % len(p.tokens)
+			return t.value, nil
+		}
+		if earliest.IsZero() || t.limitedUntil.Before(earliest) {
+			earliest = t.limitedUntil
+		}
+	}
+	return "", &RateLimitError{
+		ResetAt: earliest,
+		Message: fmt.Sprintf("all %d tokens for %s are rate limited", len(p.tokens), owner),
+	}
+}
+
+func (r *TokenRegistry) MarkRateLimited(owner, token string, resetAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Without a usable reset time, rest the token long enough that it isn't handed straight back
+	if resetAt.IsZero() {
+		resetAt = time.Now().Add(time.Hour)
+	} else if resetAt.Before(time.Now()) {
+		resetAt = time.Now().Add(time.Minute)
+	}
+	for _, t := range r.poolFor(owner).tokens {
+		if t.value == token {
+			t.limitedUntil = resetAt
+		}
+	}
+}
+
+func (r *TokenRegistry) PoolSize(owner string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.poolFor(owner).tokens)
+}
+
+// poolFor returns the owner's pool, or the default one. Callers hold r.mu.
+func (r *TokenRegistry) poolFor(owner string) *tokenPool {
+	if p, ok := r.owners[strings.ToLower(owner)]; ok && len(p.tokens) > 0 {
+		return p
+	}
+	return r.fallback
+}
+
+// ParseOwnerTokens reads "owner:token1,token2;other-owner:token3" into a registry
+// whose default pool is defaultTokens
+func ParseOwnerTokens(spec string, defaultTokens []string) (*TokenRegistry, error) {
+	registry := NewTokenRegistry(defaultTokens)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		owner, tokens, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(owner) == "" {
+			// Don't echo the entry back, it may contain a token
+			return nil, fmt.Errorf("invalid owner token entry, expected owner:token[,token]")
+		}
+		registry.Add(strings.TrimSpace(owner), strings.Split(tokens, ",")...)
+	}
+	return registry, nil
+}
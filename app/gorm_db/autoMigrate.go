@@ -23,5 +23,44 @@ func Automigration(db *gorm.DB) error {
 	if err := db.AutoMigrate(&domain.PRSnapshot{}); err != nil {
 		log.Fatalf("Migration error for Pr snapshot:%v", err)
 	}
+	if err := db.AutoMigrate(&domain.PRStatusTransition{}); err != nil {
+		log.Fatalf("Migration error for Pr status transition:%v", err)
+	}
+	if err := db.AutoMigrate(&domain.PRSnapshotFile{}); err != nil {
+		log.Fatalf("Migration error for Pr snapshot file:%v", err)
+	}
+	if err := db.AutoMigrate(&domain.PRCommit{}); err != nil {
+		log.Fatalf("Migration error for Pr commit:%v", err)
+	}
+	if err := db.AutoMigrate(&domain.WebhookDelivery{}); err != nil {
+		log.Fatalf("Migration error for webhook delivery:%v", err)
+	}
+	if err := db.AutoMigrate(&domain.RefreshToken{}); err != nil {
+		log.Fatalf("Migration error for refresh token:%v", err)
+	}
+	if err := db.AutoMigrate(&domain.RevokedToken{}); err != nil {
+		log.Fatalf("Migration error for revoked token:%v", err)
+	}
+	if err := uniquePRLinks(db); err != nil {
+		log.Fatalf("Migration error for PullRequest links:%v", err)
+	}
+	if err := uniqueDailySnapshots(db); err != nil {
+		log.Fatalf("Migration error for Pr snapshot dates:%v", err)
+	}
+	if err := normalizePRStatuses(db); err != nil {
+		log.Fatalf("Migration error for PullRequest status:%v", err)
+	}
 	return nil
 }
+
+// normalizePRStatuses fixes statuses stored as typed by users before they were
+// ignored on save. Anything that isn't a known status becomes open so the sync
+// and the next fetch correct it.
+func normalizePRStatuses(db *gorm.DB) error {
+	if err := db.Exec("UPDATE pull_requests SET status = LOWER(TRIM(status)) WHERE status <> LOWER(TRIM(status))").Error; err != nil {
+		return err
+	}
+	return db.Table("pull_requests").
+		Where("status NOT IN ?", []string{domain.PRStatusOpen, domain.PRStatusDraft, domain.PRStatusMerged, domain.PRStatusClosed}).
+		Update("status", domain.PRStatusOpen).Error
+}
@@ -0,0 +1,123 @@
+package gormdb
+
+import (
+	"fmt"
+	"log"
+	"pr-mail/app/domain"
+	"pr-mail/app/provider"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// prLinkIndex keeps one row per employee and PR, links are compared case-insensitively
+const prLinkIndex = "idx_pr_employee_link"
+
+// uniquePRLinks rewrites links saved before they were normalized to their
+// canonical form, merges the rows that turn out to be the same PR and then
+// adds the unique index. It only runs until the index exists.
+func uniquePRLinks(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&domain.PullRequest{}, prLinkIndex) {
+		return nil
+	}
+
+	var prs []domain.PullRequest
+	if err := db.Select("id", "employee_id", "pr_link").Order("id").Find(&prs).Error; err != nil {
+		return err
+	}
+
+	providers := provider.RegistryFromEnv()
+	keepers := map[string]uint{} // employee and lowercased link to the oldest row's ID
+	for _, pr := range prs {
+		link := pr.PRLink
+		if _, canonical, err := providers.Normalize(link); err == nil {
+			link = canonical
+		}
+		key := fmt.Sprintf("%d %s", pr.EmployeeID, strings.ToLower(link))
+
+		if keeper, ok := keepers[key]; ok {
+			if err := mergePR(db, keeper, pr.ID); err != nil {
+				return fmt.Errorf("merge PR %d into %d: %w", pr.ID, keeper, err)
+			}
+			log.Printf("Merged duplicate PR %d into %d (%s)", pr.ID, keeper, link)
+			continue
+		}
+		keepers[key] = pr.ID
+
+		if link != pr.PRLink {
+			if err := db.Model(&domain.PullRequest{}).Where("id = ?", pr.ID).Update("pr_link", link).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + prLinkIndex + " ON pull_requests (employee_id, LOWER(pr_link))").Error
+}
+
+// mergePR moves the history of a duplicate PR row onto the row kept and
+// deletes the duplicate. Where both have a snapshot for the same day or the
+// same commit, the kept row's wins.
+func mergePR(db *gorm.DB, keepID, dupID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		sameDay := tx.Model(&domain.PRSnapshot{}).Select("date").Where("pr_id = ?", keepID)
+		var clashing []uint
+		if err := tx.Model(&domain.PRSnapshot{}).
+			Where("pr_id = ? AND date IN (?)", dupID, sameDay).
+			Pluck("id", &clashing).Error; err != nil {
+			return err
+		}
+		if len(clashing) > 0 {
+			if err := tx.Where("snapshot_id IN ?", clashing).Delete(&domain.PRSnapshotFile{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", clashing).Delete(&domain.PRSnapshot{}).Error; err != nil {
+				return err
+			}
+		}
+
+		sameSHA := tx.Model(&domain.PRCommit{}).Select("sha").Where("pr_id = ?", keepID)
+		if err := tx.Where("pr_id = ? AND sha IN (?)", dupID, sameSHA).Delete(&domain.PRCommit{}).Error; err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{&domain.PRSnapshot{}, &domain.PRSnapshotFile{}, &domain.PRCommit{}, &domain.PRStatusTransition{}} {
+			if err := tx.Model(model).Where("pr_id = ?", dupID).Update("pr_id", keepID).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&domain.PullRequest{}, dupID).Error
+	})
+}
+
+// snapshotDayIndex keeps one snapshot per employee, PR and day
+const snapshotDayIndex = "idx_pr_snapshot_day"
+
+// uniqueDailySnapshots deletes the extra same-day snapshots saved by
+// concurrent refreshes, keeping the latest one, and then adds the unique index
+// snapshots are upserted on. It only runs until the index exists.
+func uniqueDailySnapshots(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&domain.PRSnapshot{}, snapshotDayIndex) {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var extra []uint
+		err := tx.Raw(`SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY employee_id, pr_id, date ORDER BY id DESC) AS n
+				FROM pr_snapshots
+			) s WHERE n > 1`).Scan(&extra).Error
+		if err != nil {
+			return err
+		}
+		if len(extra) > 0 {
+			if err := tx.Where("snapshot_id IN ?", extra).Delete(&domain.PRSnapshotFile{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", extra).Delete(&domain.PRSnapshot{}).Error; err != nil {
+				return err
+			}
+			log.Printf("Deleted %d duplicate same-day PR snapshots", len(extra))
+		}
+		return tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + snapshotDayIndex + " ON pr_snapshots (employee_id, pr_id, date)").Error
+	})
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"pr-mail/pkg/jwt"
 	"pr-mail/pkg/middleware"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -27,17 +30,104 @@ func GetAdminUsernameFromContext(ctx context.Context) (string, error) {
 	return username, nil
 }
 
+// GetClaimsFromContext retrieves the validated access token claims from context
+func GetClaimsFromContext(ctx context.Context) (*jwt.Claims, error) {
+	claims, ok := ctx.Value(middleware.ClaimsKey).(*jwt.Claims)
+	if !ok {
+		return nil, errors.New("token claims not found in context")
+	}
+	return claims, nil
+}
+
+// PRRef identifies a pull request on a code host. Host is lowercased, without
+// www., and includes any path prefix an enterprise server is served under.
+// Owner may contain slashes for nested GitLab groups.
+type PRRef struct {
+	Host   string
+	Owner  string
+	Repo   string
+	Number int
+}
+
+// Path is the repository's full path, e.g. owner/repo or group/subgroup/project
+func (r PRRef) Path() string {
+	return r.Owner + "/" + r.Repo
+}
+
+// URL is the canonical GitHub style link stored for the pull request
+func (r PRRef) URL() string {
+	return fmt.Sprintf("https://%s/%s/%s/pull/%d", r.Host, r.Owner, r.Repo, r.Number)
+}
+
+var prShorthand = regexp.MustCompile(`^([A-Za-z0-9_.-]+)/([A-Za-z0-9_.-]+)#([0-9]+)$`)
+
+// ParsePRRef parses a GitHub pull request link. It accepts http or https or no
+// scheme, www., enterprise hosts and path prefixes, trailing segments such as
+// /files, query strings and fragments, and the owner/repo#123 shorthand for github.com.
+func ParsePRRef(link string) (PRRef, error) {
+	link = strings.TrimSpace(link)
+	if m := prShorthand.FindStringSubmatch(link); m != nil {
+		number, err := strconv.Atoi(m[3])
+		if err != nil || number <= 0 {
+			return PRRef{}, fmt.Errorf("invalid PR number in link")
+		}
+		return PRRef{Host: "github.com", Owner: m[1], Repo: m[2], Number: number}, nil
+	}
+
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return PRRef{}, fmt.Errorf("invalid PR link")
+	}
+
+	var segments []string
+	for _, s := range strings.Split(u.Path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	// The first pull or pulls segment with an owner and repo before it and a number after
+	for i := 2; i+1 < len(segments); i++ {
+		if segments[i] != "pull" && segments[i] != "pulls" {
+			continue
+		}
+		number, err := strconv.Atoi(segments[i+1])
+		if err != nil || number <= 0 {
+			return PRRef{}, fmt.Errorf("invalid PR number in link")
+		}
+		host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+		if prefix := segments[:i-2]; len(prefix) > 0 {
+			host += "/" + strings.Join(prefix, "/")
+		}
+		return PRRef{Host: host, Owner: segments[i-2], Repo: segments[i-1], Number: number}, nil
+	}
+	return PRRef{}, fmt.Errorf("invalid PR link")
+}
+
+// ParseGitHubPRRef parses a link with ParsePRRef and rejects it unless it is
+// on host, the configured GitHub server's web host. The owner/repo#123
+// shorthand is taken to be on host.
+func ParseGitHubPRRef(link, host string) (PRRef, error) {
+	ref, err := ParsePRRef(link)
+	if err != nil {
+		return PRRef{}, err
+	}
+	if prShorthand.MatchString(strings.TrimSpace(link)) {
+		ref.Host = host
+	}
+	if ref.Host != host {
+		return PRRef{}, fmt.Errorf("PR link host %s is not the configured GitHub host %s", ref.Host, host)
+	}
+	return ref, nil
+}
+
+// ParsePRLink returns the owner, repo and number of a GitHub pull request link, see ParsePRRef
 func ParsePRLink(link string) (owner, repo string, prNumber int, err error) {
-	// Example: https://github.com/srz-vishnu/E-cart-FE/pull/10
-	parts := strings.Split(link, "/")
-	if len(parts) < 7 {
-		return "", "", 0, fmt.Errorf("invalid PR link")
-	}
-	owner = parts[3]
-	repo = parts[4]
-	prNumber, err = strconv.Atoi(parts[6])
+	ref, err := ParsePRRef(link)
 	if err != nil {
-		return "", "", 0, fmt.Errorf("invalid PR number in link")
+		return "", "", 0, err
 	}
-	return owner, repo, prNumber, nil
+	return ref.Owner, ref.Repo, ref.Number, nil
 }
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"context"
+	"pr-mail/app/dto"
+	"pr-mail/app/repo"
+	"pr-mail/app/service"
+	"pr-mail/pkg/schedule"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const tokenCleanupInterval = 24 * time.Hour
+
+// StartJobs starts the background jobs that are configured, they stop with ctx
+func StartJobs(ctx context.Context, db *gorm.DB, deps Deps) {
+	if interval := service.StatusSyncIntervalFromEnv(); interval > 0 {
+		statusSync := service.NewStatusSyncService(deps.prRepo, deps.refresher)
+		go schedule.Every(ctx, "PR status sync", interval, func(ctx context.Context) {
+			if _, err := statusSync.SyncPRStatuses(ctx); err != nil {
+				log.Error().Err(err).Msg("PR status sync failed")
+			}
+		})
+	}
+
+	// Expired refresh tokens and revocations can't be presented any more
+	authRepo := repo.NewAuthRepo(db)
+	go schedule.Every(ctx, "expired token cleanup", tokenCleanupInterval, func(ctx context.Context) {
+		deleted, err := authRepo.DeleteExpiredTokens(time.Now())
+		if err != nil {
+			log.Error().Err(err).Msg("Expired token cleanup failed")
+			return
+		}
+		log.Info().Msgf("Deleted %d expired tokens", deleted)
+	})
+
+	discoveryCfg := service.DiscoveryConfigFromEnv()
+	if len(discoveryCfg.Orgs) > 0 {
+		discovery := service.NewDiscoveryService(deps.prRepo, deps.ghClient, discoveryCfg)
+		go schedule.Every(ctx, "PR discovery", discoveryCfg.Interval, func(ctx context.Context) {
+			if _, err := discovery.DiscoverPRs(ctx); err != nil {
+				log.Error().Err(err).Msg("PR discovery failed")
+			}
+		})
+	}
+}
+
+// RunDiscovery runs PR discovery once, for the command line
+func RunDiscovery(ctx context.Context, db *gorm.DB) (*dto.DiscoveryResponse, error) {
+	deps := NewDeps(db)
+	return service.NewDiscoveryService(deps.prRepo, deps.ghClient, service.DiscoveryConfigFromEnv()).DiscoverPRs(ctx)
+}
+
+// RunStatusSync rechecks every open and draft PR once, for the command line
+func RunStatusSync(ctx context.Context, db *gorm.DB) (*dto.StatusSyncResponse, error) {
+	deps := NewDeps(db)
+	return service.NewStatusSyncService(deps.prRepo, deps.refresher).SyncPRStatuses(ctx)
+}
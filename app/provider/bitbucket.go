@@ -0,0 +1,358 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"pr-mail/app/domain"
+	"pr-mail/app/dto"
+	helper "pr-mail/app/helper"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultBitbucketAPIURL is the Bitbucket Cloud API, links are on bitbucket.org
+	DefaultBitbucketAPIURL = "https://api.bitbucket.org/2.0"
+
+	bitbucketHost = "bitbucket.org"
+)
+
+type BitbucketConfig struct {
+	// APIURL overrides the Bitbucket Cloud API root, mostly for tests
+	APIURL string
+	// Username and AppPassword authenticate with HTTP basic auth
+	Username    string
+	AppPassword string
+	// Token is a repository, project or workspace access token, used instead of an app password
+	Token string
+	// Transport used by the underlying http.Client, http.DefaultTransport when nil
+	Transport http.RoundTripper
+}
+
+// BitbucketConfigFromEnv builds a BitbucketConfig from BITBUCKET_USERNAME,
+// BITBUCKET_APP_PASSWORD and BITBUCKET_TOKEN
+func BitbucketConfigFromEnv() BitbucketConfig {
+	return BitbucketConfig{
+		Username:    os.Getenv("BITBUCKET_USERNAME"),
+		AppPassword: os.Getenv("BITBUCKET_APP_PASSWORD"),
+		Token:       os.Getenv("BITBUCKET_TOKEN"),
+	}
+}
+
+type bitbucketProvider struct {
+	apiClient
+}
+
+func NewBitbucket(cfg BitbucketConfig) Provider {
+	apiURL := strings.TrimRight(cfg.APIURL, "/")
+	if apiURL == "" {
+		apiURL = DefaultBitbucketAPIURL
+	}
+	auth := func(req *http.Request) {
+		switch {
+		case cfg.Token != "":
+			req.Header.Set("Authorization", "Bearer "+cfg.Token)
+		case cfg.Username != "":
+			req.SetBasicAuth(cfg.Username, cfg.AppPassword)
+		}
+	}
+	return &bitbucketProvider{apiClient: newAPIClient("bitbucket", apiURL, cfg.Transport, auth)}
+}
+
+func (p *bitbucketProvider) Name() string {
+	return "bitbucket"
+}
+
+// ParseLink accepts https://bitbucket.org/workspace/repo/pull-requests/12
+// with anything after the number, such as /diff or #comment-1
+func (p *bitbucketProvider) ParseLink(link string) (helper.PRRef, bool) {
+	if hostOf(link) != bitbucketHost {
+		return helper.PRRef{}, false
+	}
+	u, err := parseURL(link)
+	if err != nil {
+		return helper.PRRef{}, false
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 4 || parts[2] != "pull-requests" || parts[0] == "" || parts[1] == "" {
+		return helper.PRRef{}, false
+	}
+	number, err := strconv.Atoi(parts[3])
+	if err != nil || number <= 0 {
+		return helper.PRRef{}, false
+	}
+	return helper.PRRef{Host: bitbucketHost, Owner: parts[0], Repo: parts[1], Number: number}, true
+}
+
+func (p *bitbucketProvider) Link(ref helper.PRRef) string {
+	return fmt.Sprintf("https://%s/%s/pull-requests/%d", bitbucketHost, ref.Path(), ref.Number)
+}
+
+type bitbucketPR struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	State       string    `json:"state"` // OPEN, MERGED, DECLINED, SUPERSEDED
+	Draft       bool      `json:"draft"`
+	UpdatedOn   time.Time `json:"updated_on"`
+	Source      struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+		Commit struct {
+			Hash string `json:"hash"`
+		} `json:"commit"`
+	} `json:"source"`
+	Destination struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+	} `json:"destination"`
+	Participants []struct {
+		User struct {
+			Nickname string `json:"nickname"`
+		} `json:"user"`
+		Role     string `json:"role"` // REVIEWER or PARTICIPANT
+		Approved bool   `json:"approved"`
+		State    string `json:"state"` // approved, changes_requested or empty
+	} `json:"participants"`
+}
+
+type bitbucketDiffstat struct {
+	Status       string `json:"status"` // added, removed, modified, renamed
+	LinesAdded   int    `json:"lines_added"`
+	LinesRemoved int    `json:"lines_removed"`
+	Old          *struct {
+		Path string `json:"path"`
+	} `json:"old"`
+	New *struct {
+		Path string `json:"path"`
+	} `json:"new"`
+}
+
+type bitbucketCommit struct {
+	Hash    string    `json:"hash"`
+	Message string    `json:"message"`
+	Date    time.Time `json:"date"`
+	Author  struct {
+		Raw string `json:"raw"` // Name <email>
+	} `json:"author"`
+}
+
+type bitbucketStatus struct {
+	Name  string `json:"name"`
+	Key   string `json:"key"`
+	State string `json:"state"` // SUCCESSFUL, FAILED, INPROGRESS, STOPPED
+}
+
+func (p *bitbucketProvider) FetchPR(ctx context.Context, ref helper.PRRef) (*PRData, error) {
+	prPath := fmt.Sprintf("/repositories/%s/%s/pullrequests/%d", url.PathEscape(ref.Owner), url.PathEscape(ref.Repo), ref.Number)
+
+	var pr bitbucketPR
+	if _, err := p.get(ctx, prPath, &pr); err != nil {
+		return nil, err
+	}
+
+	var diffstat []bitbucketDiffstat
+	if err := p.getPages(ctx, prPath+"/diffstat?pagelen=100", func(body []byte) (string, error) {
+		var page struct {
+			Values []bitbucketDiffstat `json:"values"`
+			Next   string              `json:"next"`
+		}
+		err := json.Unmarshal(body, &page)
+		diffstat = append(diffstat, page.Values...)
+		return page.Next, err
+	}); err != nil {
+		return nil, err
+	}
+
+	var commits []bitbucketCommit
+	if err := p.getPages(ctx, prPath+"/commits?pagelen=100", func(body []byte) (string, error) {
+		var page struct {
+			Values []bitbucketCommit `json:"values"`
+			Next   string            `json:"next"`
+		}
+		err := json.Unmarshal(body, &page)
+		commits = append(commits, page.Values...)
+		return page.Next, err
+	}); err != nil {
+		return nil, err
+	}
+
+	data := &PRData{
+		PR:      p.toPRResponse(&pr),
+		Files:   []dto.GitHubPRFile{},
+		Commits: []dto.GitHubCommit{},
+	}
+
+	for _, d := range diffstat {
+		file := dto.GitHubPRFile{
+			Status:    d.Status,
+			Additions: d.LinesAdded,
+			Deletions: d.LinesRemoved,
+			Changes:   d.LinesAdded + d.LinesRemoved,
+		}
+		switch {
+		case d.New != nil:
+			file.Filename = d.New.Path
+		case d.Old != nil:
+			file.Filename = d.Old.Path
+		}
+		data.Files = append(data.Files, file)
+		data.PR.Additions += d.LinesAdded
+		data.PR.Deletions += d.LinesRemoved
+	}
+	data.PR.ChangedFiles = len(diffstat)
+
+	for _, c := range commits {
+		var commit dto.GitHubCommit
+		commit.SHA = c.Hash
+		commit.Commit.Message = c.Message
+		commit.Commit.Author.Name, commit.Commit.Author.Email = parseRawAuthor(c.Author.Raw)
+		commit.Commit.Author.Date = c.Date
+		data.Commits = append(data.Commits, commit)
+	}
+	data.PR.Commits = len(commits)
+
+	data.ReviewStatus, data.Reviewers = bitbucketReviewStatus(&pr)
+	data.CIState, data.FailingChecks = p.ciStatus(ctx, prPath)
+
+	return data, nil
+}
+
+func (p *bitbucketProvider) FetchPRState(ctx context.Context, ref helper.PRRef) (*dto.GitHubPRResponse, error) {
+	var pr bitbucketPR
+	if _, err := p.get(ctx, fmt.Sprintf("/repositories/%s/%s/pullrequests/%d", url.PathEscape(ref.Owner), url.PathEscape(ref.Repo), ref.Number), &pr); err != nil {
+		return nil, err
+	}
+	return p.toPRResponse(&pr), nil
+}
+
+// toPRResponse maps PR fields onto the GitHub shaped response the pipeline stores.
+// Bitbucket has no merged/closed timestamps, the last update is the closest there is.
+func (p *bitbucketProvider) toPRResponse(pr *bitbucketPR) *dto.GitHubPRResponse {
+	res := &dto.GitHubPRResponse{
+		Title: pr.Title,
+		Body:  pr.Description,
+		State: "closed",
+		Draft: pr.Draft,
+	}
+	switch pr.State {
+	case "OPEN":
+		res.State = "open"
+	case "MERGED":
+		updated := pr.UpdatedOn
+		res.Merged, res.MergedAt, res.ClosedAt = true, &updated, &updated
+	default:
+		updated := pr.UpdatedOn
+		res.ClosedAt = &updated
+	}
+	res.Base.Ref = pr.Destination.Branch.Name
+	res.Head.Ref = pr.Source.Branch.Name
+	res.Head.SHA = pr.Source.Commit.Hash
+	return res
+}
+
+// ciStatus summarizes the build statuses reported on the PR's head commit, best effort
+func (p *bitbucketProvider) ciStatus(ctx context.Context, prPath string) (string, []string) {
+	var statuses []bitbucketStatus
+	if err := p.getPages(ctx, prPath+"/statuses?pagelen=100", func(body []byte) (string, error) {
+		var page struct {
+			Values []bitbucketStatus `json:"values"`
+			Next   string            `json:"next"`
+		}
+		err := json.Unmarshal(body, &page)
+		statuses = append(statuses, page.Values...)
+		return page.Next, err
+	}); err != nil || len(statuses) == 0 {
+		return domain.CINone, nil
+	}
+
+	var failing []string
+	pending := false
+	for _, s := range statuses {
+		name := s.Name
+		if name == "" {
+			name = s.Key
+		}
+		switch s.State {
+		case "FAILED", "STOPPED":
+			failing = appendUnique(failing, name)
+		case "INPROGRESS":
+			pending = true
+		}
+	}
+	sort.Strings(failing)
+
+	switch {
+	case len(failing) > 0:
+		return domain.CIFailure, failing
+	case pending:
+		return domain.CIPending, nil
+	}
+	return domain.CISuccess, nil
+}
+
+// bitbucketReviewStatus follows the same precedence as github.ReviewStatus:
+// changes requested, then reviewers still to respond, then approved
+func bitbucketReviewStatus(pr *bitbucketPR) (string, []string) {
+	var reviewers []string
+	approved, changesRequested, pending := false, false, false
+	for _, participant := range pr.Participants {
+		isReviewer := participant.Role == "REVIEWER"
+		if !isReviewer && participant.State == "" && !participant.Approved {
+			continue // only commented
+		}
+		reviewers = appendUnique(reviewers, participant.User.Nickname)
+		switch {
+		case participant.State == "changes_requested":
+			changesRequested = true
+		case participant.Approved:
+			approved = true
+		case isReviewer:
+			pending = true
+		}
+	}
+	sort.Strings(reviewers)
+
+	switch {
+	case changesRequested:
+		return domain.ReviewChangesRequested, reviewers
+	case pending:
+		return domain.ReviewAwaiting, reviewers
+	case approved:
+		return domain.ReviewApproved, reviewers
+	}
+	return domain.ReviewAwaiting, reviewers
+}
+
+// getPages follows the next links of Bitbucket's paged responses until visit
+// returns no next link or maxPages is reached
+func (p *bitbucketProvider) getPages(ctx context.Context, path string, visit func(body []byte) (next string, err error)) error {
+	next := path
+	for i := 0; i < maxPages && next != ""; i++ {
+		_, body, err := p.request(ctx, next)
+		if err != nil {
+			return err
+		}
+		if next, err = visit(body); err != nil {
+			return fmt.Errorf("failed to decode bitbucket api response: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseRawAuthor splits a git author line like "Jane Doe <jane@example.com>"
+func parseRawAuthor(raw string) (name, email string) {
+	if addr, err := mail.ParseAddress(raw); err == nil {
+		return addr.Name, addr.Address
+	}
+	return strings.TrimSpace(raw), ""
+}
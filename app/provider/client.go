@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	providerTimeout = 30 * time.Second
+
+	// maxPages stops pagination of very large pull requests
+	maxPages = 30
+)
+
+// apiClient is the JSON over HTTP plumbing shared by the providers
+type apiClient struct {
+	name    string
+	baseURL string // API root that relative paths are appended to
+	http    *http.Client
+	auth    func(req *http.Request)
+}
+
+func newAPIClient(name, baseURL string, transport http.RoundTripper, auth func(req *http.Request)) apiClient {
+	return apiClient{
+		name:    name,
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: providerTimeout, Transport: transport},
+		auth:    auth,
+	}
+}
+
+// get sends an authenticated GET and decodes the JSON body into out
+func (c *apiClient) get(ctx context.Context, path string, out interface{}) (*http.Response, error) {
+	resp, body, err := c.request(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("failed to decode %s api response: %w", c.name, err)
+	}
+	return resp, nil
+}
+
+// post sends body as JSON in an authenticated POST and decodes the JSON response into out
+func (c *apiClient) post(ctx context.Context, path string, body []byte, out interface{}) error {
+	_, respBody, err := c.send(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode %s api response: %w", c.name, err)
+	}
+	return nil
+}
+
+// request sends an authenticated GET for path, which may also be an absolute
+// URL such as a next page link, and returns the body of a 2xx response
+func (c *apiClient) request(ctx context.Context, path string) (*http.Response, []byte, error) {
+	return c.send(ctx, http.MethodGet, path, nil)
+}
+
+// send is request for any method, body is sent as JSON when not nil
+func (c *apiClient) send(ctx context.Context, method, path string, reqBody []byte) (*http.Response, []byte, error) {
+	reqURL := path
+	if !strings.HasPrefix(path, "http") {
+		reqURL = c.baseURL + path
+	}
+	var bodyReader io.Reader
+	if reqBody != nil {
+		bodyReader = bytes.NewReader(reqBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bodyReader)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.auth != nil {
+		c.auth(req)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, &HTTPError{Provider: c.name, StatusCode: resp.StatusCode, Message: errorMessage(body)}
+	}
+	return resp, body, nil
+}
+
+// errorMessage pulls the message out of an error body, GitLab and Gitea send
+// {"message": ...} while Bitbucket nests it as {"error": {"message": ...}}
+func errorMessage(body []byte) string {
+	var payload struct {
+		Message interface{} `json:"message"`
+		Error   struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		if payload.Message != nil {
+			return fmt.Sprint(payload.Message)
+		}
+		if payload.Error.Message != "" {
+			return payload.Error.Message
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
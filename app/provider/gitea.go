@@ -0,0 +1,221 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"pr-mail/app/dto"
+	"pr-mail/app/github"
+	helper "pr-mail/app/helper"
+	"strconv"
+	"strings"
+)
+
+// giteaPageSize is the page size asked for, instances cap it at their MAX_RESPONSE_ITEMS
+const giteaPageSize = 50
+
+// GiteaConfig configures one Gitea or Forgejo instance, they share the same API
+type GiteaConfig struct {
+	// BaseURL of the instance, including any path prefix it is served under
+	BaseURL string
+	// Token is an access token sent as "Authorization: token ..."
+	Token string
+	// Transport used by the underlying http.Client, http.DefaultTransport when nil
+	Transport http.RoundTripper
+}
+
+// GiteaConfigsFromEnv returns the instances set in GITEA_URL/GITEA_TOKEN and
+// FORGEJO_URL/FORGEJO_TOKEN, each with its own token
+func GiteaConfigsFromEnv() []GiteaConfig {
+	var configs []GiteaConfig
+	for _, prefix := range []string{"GITEA", "FORGEJO"} {
+		if baseURL := os.Getenv(prefix + "_URL"); baseURL != "" {
+			configs = append(configs, GiteaConfig{BaseURL: baseURL, Token: os.Getenv(prefix + "_TOKEN")})
+		}
+	}
+	return configs
+}
+
+type giteaProvider struct {
+	apiClient
+	webURL   string
+	host     string
+	basePath string
+}
+
+func NewGitea(cfg GiteaConfig) Provider {
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	basePath := ""
+	if u, err := url.Parse(baseURL); err == nil {
+		basePath = strings.TrimRight(u.Path, "/")
+	}
+	auth := func(req *http.Request) {
+		if cfg.Token != "" {
+			req.Header.Set("Authorization", "token "+cfg.Token)
+		}
+	}
+	return &giteaProvider{
+		apiClient: newAPIClient("gitea", baseURL+"/api/v1", cfg.Transport, auth),
+		webURL:    baseURL,
+		host:      hostOf(baseURL),
+		basePath:  basePath,
+	}
+}
+
+func (p *giteaProvider) Name() string {
+	return "gitea"
+}
+
+// ParseLink accepts https://host[/prefix]/owner/repo/pulls/12 with anything
+// after the number, such as /files or #issuecomment-1
+func (p *giteaProvider) ParseLink(link string) (helper.PRRef, bool) {
+	u, err := parseURL(link)
+	if err != nil || p.host == "" || hostOf(link) != p.host {
+		return helper.PRRef{}, false
+	}
+
+	path := strings.TrimPrefix(u.Path, p.basePath)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 4 || parts[2] != "pulls" || parts[0] == "" || parts[1] == "" {
+		return helper.PRRef{}, false
+	}
+	number, err := strconv.Atoi(parts[3])
+	if err != nil || number <= 0 {
+		return helper.PRRef{}, false
+	}
+	return helper.PRRef{Host: p.host + p.basePath, Owner: parts[0], Repo: parts[1], Number: number}, true
+}
+
+// Link is the pull request's web link on the instance
+func (p *giteaProvider) Link(ref helper.PRRef) string {
+	return fmt.Sprintf("%s/%s/pulls/%d", p.webURL, ref.Path(), ref.Number)
+}
+
+type giteaReview struct {
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	State string `json:"state"` // APPROVED, REQUEST_CHANGES, COMMENT, REQUEST_REVIEW, PENDING
+}
+
+type giteaCombinedStatus struct {
+	Statuses []struct {
+		Context string `json:"context"`
+		Status  string `json:"status"` // pending, success, error, failure, warning
+	} `json:"statuses"`
+}
+
+func (p *giteaProvider) FetchPR(ctx context.Context, ref helper.PRRef) (*PRData, error) {
+	repoPath := fmt.Sprintf("/repos/%s/%s", url.PathEscape(ref.Owner), url.PathEscape(ref.Repo))
+	prPath := fmt.Sprintf("%s/pulls/%d", repoPath, ref.Number)
+
+	// Gitea's pull request payload mirrors GitHub's, so it decodes straight into the shared type
+	var pr struct {
+		dto.GitHubPRResponse
+		RequestedReviewers []dto.GitHubUser `json:"requested_reviewers"`
+	}
+	if _, err := p.get(ctx, prPath, &pr); err != nil {
+		return nil, err
+	}
+
+	if isWorkInProgress(pr.Title) {
+		pr.Draft = true // Gitea marks drafts with a title prefix
+	}
+
+	data := &PRData{
+		PR:      &pr.GitHubPRResponse,
+		Files:   []dto.GitHubPRFile{},
+		Commits: []dto.GitHubCommit{},
+	}
+
+	if err := p.getPages(func(page int) (int, error) {
+		var files []dto.GitHubPRFile
+		_, err := p.get(ctx, fmt.Sprintf("%s/files?limit=%d&page=%d", prPath, giteaPageSize, page), &files)
+		data.Files = append(data.Files, files...)
+		return len(files), err
+	}); err != nil {
+		return nil, err
+	}
+	if data.PR.ChangedFiles == 0 {
+		data.PR.ChangedFiles = len(data.Files) // not sent by older instances
+	}
+
+	if err := p.getPages(func(page int) (int, error) {
+		var commits []dto.GitHubCommit
+		_, err := p.get(ctx, fmt.Sprintf("%s/commits?limit=%d&page=%d&stat=false&files=false", prPath, giteaPageSize, page), &commits)
+		data.Commits = append(data.Commits, commits...)
+		return len(commits), err
+	}); err != nil {
+		return nil, err
+	}
+	data.PR.Commits = len(data.Commits)
+
+	// Reviews and CI are best effort, as they are for GitHub
+	reviewData := &dto.GitHubReviewData{Requested: dto.GitHubRequestedReviewers{Users: pr.RequestedReviewers}}
+	var reviews []giteaReview
+	if _, err := p.get(ctx, prPath+"/reviews?limit=50", &reviews); err == nil {
+		for _, r := range reviews {
+			reviewData.Reviews = append(reviewData.Reviews, dto.GitHubReview{
+				User:  dto.GitHubUser{Login: r.User.Login},
+				State: giteaReviewState(r.State),
+			})
+		}
+	}
+	data.ReviewStatus, data.Reviewers = github.ReviewStatus(reviewData)
+
+	ciData := &dto.GitHubCIData{}
+	var status giteaCombinedStatus
+	if _, err := p.get(ctx, fmt.Sprintf("%s/commits/%s/status", repoPath, url.PathEscape(data.PR.Head.SHA)), &status); err == nil {
+		for _, s := range status.Statuses {
+			ciData.Status.Statuses = append(ciData.Status.Statuses, dto.GitHubCommitStatus{Context: s.Context, State: s.Status})
+		}
+	}
+	data.CIState, data.FailingChecks = github.CIStatus(ciData)
+
+	return data, nil
+}
+
+func (p *giteaProvider) FetchPRState(ctx context.Context, ref helper.PRRef) (*dto.GitHubPRResponse, error) {
+	var pr dto.GitHubPRResponse
+	if _, err := p.get(ctx, fmt.Sprintf("/repos/%s/%s/pulls/%d", url.PathEscape(ref.Owner), url.PathEscape(ref.Repo), ref.Number), &pr); err != nil {
+		return nil, err
+	}
+	if isWorkInProgress(pr.Title) {
+		pr.Draft = true
+	}
+	return &pr, nil
+}
+
+// isWorkInProgress checks for Gitea's default WIP title prefixes
+func isWorkInProgress(title string) bool {
+	title = strings.ToUpper(strings.TrimSpace(title))
+	return strings.HasPrefix(title, "WIP:") || strings.HasPrefix(title, "[WIP]")
+}
+
+// giteaReviewState maps Gitea's review states onto GitHub's
+func giteaReviewState(state string) string {
+	switch state {
+	case "REQUEST_CHANGES":
+		return "CHANGES_REQUESTED"
+	case "COMMENT":
+		return "COMMENTED"
+	}
+	return state // APPROVED matches, REQUEST_REVIEW and PENDING are ignored like GitHub's PENDING
+}
+
+// getPages calls fetch with page numbers from 1 until it returns an empty page
+// or maxPages is reached. A short page isn't the end, as instances may cap the size.
+func (p *giteaProvider) getPages(fetch func(page int) (int, error)) error {
+	for page := 1; page <= maxPages; page++ {
+		n, err := fetch(page)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+	return nil
+}
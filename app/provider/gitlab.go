@@ -0,0 +1,438 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"pr-mail/app/domain"
+	"pr-mail/app/dto"
+	"pr-mail/app/github"
+	helper "pr-mail/app/helper"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultGitLabURL is GitLab's hosted instance
+const DefaultGitLabURL = "https://gitlab.com"
+
+type GitLabConfig struct {
+	// BaseURL of the instance, including any path prefix it is served under
+	BaseURL string
+	// Token is a personal, project or group access token sent as PRIVATE-TOKEN
+	Token string
+	// Transport used by the underlying http.Client, http.DefaultTransport when nil
+	Transport http.RoundTripper
+}
+
+// GitLabConfigFromEnv builds a GitLabConfig from GITLAB_URL and GITLAB_TOKEN
+func GitLabConfigFromEnv() GitLabConfig {
+	return GitLabConfig{
+		BaseURL: os.Getenv("GITLAB_URL"),
+		Token:   os.Getenv("GITLAB_TOKEN"),
+	}
+}
+
+type gitLabProvider struct {
+	apiClient
+	webURL   string
+	host     string
+	basePath string
+}
+
+func NewGitLab(cfg GitLabConfig) Provider {
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = DefaultGitLabURL
+	}
+	basePath := ""
+	if u, err := url.Parse(baseURL); err == nil {
+		basePath = strings.TrimRight(u.Path, "/")
+	}
+	auth := func(req *http.Request) {
+		if cfg.Token != "" {
+			// REST takes PRIVATE-TOKEN, GraphQL only a bearer token
+			req.Header.Set("PRIVATE-TOKEN", cfg.Token)
+			req.Header.Set("Authorization", "Bearer "+cfg.Token)
+		}
+	}
+	return &gitLabProvider{
+		apiClient: newAPIClient("gitlab", baseURL+"/api/v4", cfg.Transport, auth),
+		webURL:    baseURL,
+		host:      hostOf(baseURL),
+		basePath:  basePath,
+	}
+}
+
+func (p *gitLabProvider) Name() string {
+	return "gitlab"
+}
+
+// ParseLink accepts https://host[/prefix]/group/sub/project/-/merge_requests/12
+// with anything after the number, such as /diffs or #note_1
+func (p *gitLabProvider) ParseLink(link string) (helper.PRRef, bool) {
+	u, err := parseURL(link)
+	if err != nil || hostOf(link) != p.host {
+		return helper.PRRef{}, false
+	}
+
+	path := strings.TrimPrefix(u.Path, p.basePath)
+	project, rest, ok := strings.Cut(strings.Trim(path, "/"), "/-/merge_requests/")
+	if !ok {
+		return helper.PRRef{}, false
+	}
+	numStr, _, _ := strings.Cut(rest, "/")
+	number, err := strconv.Atoi(numStr)
+	if err != nil || number <= 0 {
+		return helper.PRRef{}, false
+	}
+
+	idx := strings.LastIndex(project, "/")
+	if idx <= 0 {
+		return helper.PRRef{}, false
+	}
+	return helper.PRRef{Host: p.host + p.basePath, Owner: project[:idx], Repo: project[idx+1:], Number: number}, true
+}
+
+// Link is the merge request's web link on the instance
+func (p *gitLabProvider) Link(ref helper.PRRef) string {
+	return fmt.Sprintf("%s/%s/-/merge_requests/%d", p.webURL, ref.Path(), ref.Number)
+}
+
+type gitLabMR struct {
+	Title        string     `json:"title"`
+	Description  string     `json:"description"`
+	State        string     `json:"state"` // opened, closed, locked, merged
+	Draft        bool       `json:"draft"`
+	MergedAt     *time.Time `json:"merged_at"`
+	ClosedAt     *time.Time `json:"closed_at"`
+	SourceBranch string     `json:"source_branch"`
+	TargetBranch string     `json:"target_branch"`
+	SHA          string     `json:"sha"`
+	// ChangesCount is the number of changed files, "1000+" past GitLab's diff limits
+	ChangesCount string `json:"changes_count"`
+	Reviewers    []struct {
+		Username string `json:"username"`
+	} `json:"reviewers"`
+	HeadPipeline *struct {
+		ID     int64  `json:"id"`
+		Status string `json:"status"`
+	} `json:"head_pipeline"`
+}
+
+type gitLabDiff struct {
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	Diff        string `json:"diff"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+	// Files over GitLab's diff limits are listed with an empty diff
+	TooLarge  bool `json:"too_large"`
+	Collapsed bool `json:"collapsed"`
+}
+
+// gitLabReviewer is a reviewer's state from the MR reviewers API: unreviewed,
+// review_started, reviewed, requested_changes, approved or unapproved
+type gitLabReviewer struct {
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	State string `json:"state"`
+}
+
+type gitLabCommit struct {
+	ID           string    `json:"id"`
+	Message      string    `json:"message"`
+	AuthorName   string    `json:"author_name"`
+	AuthorEmail  string    `json:"author_email"`
+	AuthoredDate time.Time `json:"authored_date"`
+}
+
+func (p *gitLabProvider) FetchPR(ctx context.Context, ref helper.PRRef) (*PRData, error) {
+	// GitLab accepts the URL encoded full path wherever a project ID is expected
+	mrPath := fmt.Sprintf("/projects/%s/merge_requests/%d", url.PathEscape(ref.Path()), ref.Number)
+
+	var mr gitLabMR
+	if _, err := p.get(ctx, mrPath, &mr); err != nil {
+		return nil, err
+	}
+
+	var diffs []gitLabDiff
+	if err := p.getPages(ctx, mrPath+"/diffs?per_page=100", func(body []byte) (int, error) {
+		var page []gitLabDiff
+		err := json.Unmarshal(body, &page)
+		diffs = append(diffs, page...)
+		return len(page), err
+	}); err != nil {
+		return nil, err
+	}
+
+	var commits []gitLabCommit
+	if err := p.getPages(ctx, mrPath+"/commits?per_page=100", func(body []byte) (int, error) {
+		var page []gitLabCommit
+		err := json.Unmarshal(body, &page)
+		commits = append(commits, page...)
+		return len(page), err
+	}); err != nil {
+		return nil, err
+	}
+
+	var approvals struct {
+		ApprovedBy []struct {
+			User struct {
+				Username string `json:"username"`
+			} `json:"user"`
+		} `json:"approved_by"`
+	}
+	if _, err := p.get(ctx, mrPath+"/approvals", &approvals); err != nil {
+		approvals.ApprovedBy = nil // approvals are a paid feature on some instances
+	}
+
+	// Reviewer states are only listed on GitLab 13.8 and later
+	var reviewers []gitLabReviewer
+	if _, err := p.get(ctx, mrPath+"/reviewers", &reviewers); err != nil {
+		reviewers = nil
+		for _, r := range mr.Reviewers {
+			reviewers = append(reviewers, gitLabReviewer{User: r, State: "unreviewed"})
+		}
+	}
+
+	data := &PRData{
+		PR:      p.toPRResponse(&mr),
+		Files:   []dto.GitHubPRFile{},
+		Commits: []dto.GitHubCommit{},
+	}
+
+	for _, d := range diffs {
+		additions, deletions := countDiffLines(d.Diff)
+		status := "modified"
+		switch {
+		case d.NewFile:
+			status = "added"
+		case d.DeletedFile:
+			status = "removed"
+		case d.RenamedFile:
+			status = "renamed"
+		}
+		data.Files = append(data.Files, dto.GitHubPRFile{
+			Filename:  d.NewPath,
+			Status:    status,
+			Additions: additions,
+			Deletions: deletions,
+			Changes:   additions + deletions,
+		})
+		data.PR.Additions += additions
+		data.PR.Deletions += deletions
+	}
+
+	// Totals come from the whole MR, the diffs leave out large files and stop
+	// at maxPages. Counting the diffs is the fallback when the stats are unavailable.
+	data.PR.ChangedFiles = len(diffs)
+	if changes, err := strconv.Atoi(strings.TrimSuffix(mr.ChangesCount, "+")); err == nil && changes > len(diffs) {
+		data.PR.ChangedFiles = changes
+	}
+	if stats, err := p.diffStats(ctx, ref); err == nil {
+		data.PR.Additions, data.PR.Deletions = stats.Additions, stats.Deletions
+		data.PR.ChangedFiles = max(data.PR.ChangedFiles, stats.FileCount)
+	}
+	data.FilesTruncated = data.PR.ChangedFiles > len(data.Files) || strings.HasSuffix(mr.ChangesCount, "+")
+
+	for _, c := range commits {
+		var commit dto.GitHubCommit
+		commit.SHA = c.ID
+		commit.Commit.Message = c.Message
+		commit.Commit.Author.Name = c.AuthorName
+		commit.Commit.Author.Email = c.AuthorEmail
+		commit.Commit.Author.Date = c.AuthoredDate
+		data.Commits = append(data.Commits, commit)
+	}
+	data.PR.Commits = len(commits)
+
+	var approvedBy []string
+	for _, a := range approvals.ApprovedBy {
+		approvedBy = append(approvedBy, a.User.Username)
+	}
+	data.ReviewStatus, data.Reviewers = github.ReviewStatus(gitLabReviewData(reviewers, approvedBy))
+
+	data.CIState = domain.CINone
+	if mr.HeadPipeline != nil {
+		data.CIState = gitLabCIState(mr.HeadPipeline.Status)
+		if data.CIState == domain.CIFailure {
+			data.FailingChecks = p.failedJobs(ctx, ref, mr.HeadPipeline.ID)
+		}
+	}
+
+	return data, nil
+}
+
+func (p *gitLabProvider) FetchPRState(ctx context.Context, ref helper.PRRef) (*dto.GitHubPRResponse, error) {
+	var mr gitLabMR
+	if _, err := p.get(ctx, fmt.Sprintf("/projects/%s/merge_requests/%d", url.PathEscape(ref.Path()), ref.Number), &mr); err != nil {
+		return nil, err
+	}
+	return p.toPRResponse(&mr), nil
+}
+
+// toPRResponse maps MR fields onto the GitHub shaped response the pipeline stores
+func (p *gitLabProvider) toPRResponse(mr *gitLabMR) *dto.GitHubPRResponse {
+	pr := &dto.GitHubPRResponse{
+		Title:    mr.Title,
+		Body:     mr.Description,
+		State:    "closed",
+		Draft:    mr.Draft,
+		Merged:   mr.State == "merged",
+		MergedAt: mr.MergedAt,
+		ClosedAt: mr.ClosedAt,
+	}
+	if mr.State == "opened" {
+		pr.State = "open"
+	}
+	pr.Base.Ref = mr.TargetBranch
+	pr.Head.Ref = mr.SourceBranch
+	pr.Head.SHA = mr.SHA
+	return pr
+}
+
+// failedJobs names the failed jobs of a pipeline, best effort
+func (p *gitLabProvider) failedJobs(ctx context.Context, ref helper.PRRef, pipelineID int64) []string {
+	path := fmt.Sprintf("/projects/%s/pipelines/%d/jobs?scope[]=failed&per_page=100", url.PathEscape(ref.Path()), pipelineID)
+	var jobs []struct {
+		Name string `json:"name"`
+	}
+	if _, err := p.get(ctx, path, &jobs); err != nil {
+		return nil
+	}
+	var names []string
+	for _, j := range jobs {
+		names = appendUnique(names, j.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gitLabDiffStats are the MR's totals over every file, only exposed through GraphQL
+type gitLabDiffStats struct {
+	Additions int `json:"additions"`
+	Deletions int `json:"deletions"`
+	FileCount int `json:"fileCount"`
+}
+
+func (p *gitLabProvider) diffStats(ctx context.Context, ref helper.PRRef) (*gitLabDiffStats, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": `query($path: ID!, $iid: String!) {
+  project(fullPath: $path) { mergeRequest(iid: $iid) { diffStatsSummary { additions deletions fileCount } } }
+}`,
+		"variables": map[string]interface{}{"path": ref.Path(), "iid": strconv.Itoa(ref.Number)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data struct {
+			Project *struct {
+				MergeRequest *struct {
+					DiffStatsSummary *gitLabDiffStats `json:"diffStatsSummary"`
+				} `json:"mergeRequest"`
+			} `json:"project"`
+		} `json:"data"`
+	}
+	if err := p.post(ctx, p.webURL+"/api/graphql", body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data.Project == nil || resp.Data.Project.MergeRequest == nil || resp.Data.Project.MergeRequest.DiffStatsSummary == nil {
+		return nil, fmt.Errorf("gitlab graphql returned no diff stats for %s!%d", ref.Path(), ref.Number)
+	}
+	return resp.Data.Project.MergeRequest.DiffStatsSummary, nil
+}
+
+// gitLabReviewData maps reviewer states and approvals onto GitHub's review
+// shape so they aggregate the same way. Reviewers yet to review count as requested.
+func gitLabReviewData(reviewers []gitLabReviewer, approvedBy []string) *dto.GitHubReviewData {
+	data := &dto.GitHubReviewData{}
+	approved := map[string]bool{}
+	for _, username := range approvedBy {
+		approved[username] = true
+		data.Reviews = append(data.Reviews, dto.GitHubReview{User: dto.GitHubUser{Login: username}, State: "APPROVED"})
+	}
+	for _, r := range reviewers {
+		user := dto.GitHubUser{Login: r.User.Username}
+		switch r.State {
+		case "approved":
+			if !approved[user.Login] {
+				data.Reviews = append(data.Reviews, dto.GitHubReview{User: user, State: "APPROVED"})
+			}
+		case "requested_changes":
+			data.Reviews = append(data.Reviews, dto.GitHubReview{User: user, State: "CHANGES_REQUESTED"})
+		case "reviewed":
+			data.Reviews = append(data.Reviews, dto.GitHubReview{User: user, State: "COMMENTED"})
+		default: // unreviewed, review_started, unapproved
+			if !approved[user.Login] {
+				data.Requested.Users = append(data.Requested.Users, user)
+			}
+		}
+	}
+	return data
+}
+
+func gitLabCIState(status string) string {
+	switch status {
+	case "success":
+		return domain.CISuccess
+	case "failed", "canceled":
+		return domain.CIFailure
+	case "created", "waiting_for_resource", "preparing", "pending", "running", "scheduled":
+		return domain.CIPending
+	}
+	return domain.CINone // skipped, manual
+}
+
+// getPages follows X-Next-Page until visit sees an empty page or maxPages is reached
+func (p *gitLabProvider) getPages(ctx context.Context, path string, visit func(body []byte) (int, error)) error {
+	page := "1"
+	for i := 0; i < maxPages && page != ""; i++ {
+		resp, body, err := p.request(ctx, path+"&page="+page)
+		if err != nil {
+			return err
+		}
+		n, err := visit(body)
+		if err != nil {
+			return fmt.Errorf("failed to decode gitlab api response: %w", err)
+		}
+		if n == 0 {
+			return nil
+		}
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return nil
+}
+
+// countDiffLines counts added and removed lines in a unified diff body,
+// which GitLab sends without the ---/+++ file headers
+func countDiffLines(diff string) (additions, deletions int) {
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			additions++
+		case strings.HasPrefix(line, "-"):
+			deletions++
+		}
+	}
+	return additions, deletions
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"pr-mail/app/dto"
+	"pr-mail/app/github"
+	helper "pr-mail/app/helper"
+	"strings"
+)
+
+// PRData is a provider's pull request normalized to what the snapshot and
+// report pipeline stores. Files and Commits are nil when they couldn't be listed.
+type PRData struct {
+	PR            *dto.GitHubPRResponse
+	ReviewStatus  string
+	Reviewers     []string
+	CIState       string
+	FailingChecks []string
+	Files         []dto.GitHubPRFile
+	// FilesTruncated is set when Files lists fewer files than the request changes
+	FilesTruncated bool
+	Commits        []dto.GitHubCommit
+}
+
+// Provider fetches pull requests from a code host other than GitHub, which is
+// handled by the github package directly
+type Provider interface {
+	Name() string
+	// ParseLink returns the request a link points to, ok is false when the link isn't for this provider
+	ParseLink(link string) (ref helper.PRRef, ok bool)
+	// Link is the canonical web link for ref
+	Link(ref helper.PRRef) string
+	FetchPR(ctx context.Context, ref helper.PRRef) (*PRData, error)
+	// FetchPRState fetches only the request itself, for status rechecks
+	FetchPRState(ctx context.Context, ref helper.PRRef) (*dto.GitHubPRResponse, error)
+}
+
+// HTTPError is returned by providers for non-2xx responses
+type HTTPError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s api returned %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// RegistryFromEnv registers GitLab and Bitbucket Cloud, plus any Gitea or
+// Forgejo instances that are configured, each with its own credentials.
+// GitHub links are accepted for the server GITHUB_API_URL points at.
+func RegistryFromEnv() *Registry {
+	providers := []Provider{
+		NewGitLab(GitLabConfigFromEnv()),
+		NewBitbucket(BitbucketConfigFromEnv()),
+	}
+	for _, cfg := range GiteaConfigsFromEnv() {
+		providers = append(providers, NewGitea(cfg))
+	}
+	registry := NewRegistry(providers...)
+	registry.githubHost = github.WebHost(os.Getenv("GITHUB_API_URL"))
+	return registry
+}
+
+// Registry picks the provider for a PR link
+type Registry struct {
+	providers []Provider
+	// githubHost is the only host GitHub links are accepted for, github.com when empty
+	githubHost string
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	return &Registry{providers: providers}
+}
+
+// GitHubHost is the web host of the configured GitHub server
+func (r *Registry) GitHubHost() string {
+	if r == nil || r.githubHost == "" {
+		return "github.com"
+	}
+	return r.githubHost
+}
+
+// Match returns the first provider that recognizes link
+func (r *Registry) Match(link string) (Provider, helper.PRRef, bool) {
+	if r == nil {
+		return nil, helper.PRRef{}, false
+	}
+	for _, p := range r.providers {
+		if ref, ok := p.ParseLink(link); ok {
+			return p, ref, true
+		}
+	}
+	return nil, helper.PRRef{}, false
+}
+
+// Normalize parses a provider or GitHub link, or owner/repo#123 shorthand for
+// the GitHub server, and returns its ref with the canonical link to store.
+// GitHub style links on any other host are rejected, they would be fetched
+// from the configured server.
+func (r *Registry) Normalize(link string) (helper.PRRef, string, error) {
+	if p, ref, ok := r.Match(link); ok {
+		return ref, p.Link(ref), nil
+	}
+	ref, err := helper.ParseGitHubPRRef(link, r.GitHubHost())
+	if err != nil {
+		return helper.PRRef{}, "", err
+	}
+	return ref, ref.URL(), nil
+}
+
+// parseURL parses a base URL or link, which like GitHub links may be given
+// without a scheme, e.g. gitlab.com/group/repo/-/merge_requests/1
+func parseURL(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	return url.Parse(raw)
+}
+
+// hostOf returns the lowercased host of a base URL or link, without www.
+func hostOf(raw string) string {
+	u, err := parseURL(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
@@ -0,0 +1,133 @@
+package repo
+
+import (
+	"errors"
+	"pr-mail/app/domain"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRefreshTokenUsed is returned when rotating a refresh token that was
+// already used or revoked
+var ErrRefreshTokenUsed = errors.New("refresh token already used or revoked")
+
+type AuthRepo interface {
+	SaveRefreshToken(token *domain.RefreshToken) error
+	GetRefreshTokenByHash(tokenHash string) (*domain.RefreshToken, error)
+	GetRefreshTokenByAccessTokenID(jti string) (*domain.RefreshToken, error)
+	RotateRefreshToken(used *domain.RefreshToken, next *domain.RefreshToken) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(jti string) (bool, error)
+	DeleteExpiredTokens(before time.Time) (int64, error)
+}
+
+type AuthRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewAuthRepo(db *gorm.DB) AuthRepo {
+	return &AuthRepoImpl{
+		db: db,
+	}
+}
+
+func (r *AuthRepoImpl) SaveRefreshToken(token *domain.RefreshToken) error {
+	return r.db.Table("refresh_tokens").Create(token).Error
+}
+
+func (r *AuthRepoImpl) GetRefreshTokenByHash(tokenHash string) (*domain.RefreshToken, error) {
+	var token domain.RefreshToken
+	if err := r.db.Table("refresh_tokens").Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *AuthRepoImpl) GetRefreshTokenByAccessTokenID(jti string) (*domain.RefreshToken, error) {
+	var token domain.RefreshToken
+	if err := r.db.Table("refresh_tokens").Where("access_token_id = ?", jti).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken marks used as used and saves next in one transaction. The
+// conditional update makes concurrent refreshes with the same token race safely,
+// only one wins and the others get ErrRefreshTokenUsed.
+func (r *AuthRepoImpl) RotateRefreshToken(used *domain.RefreshToken, next *domain.RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Table("refresh_tokens").
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", used.ID).
+			Update("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrRefreshTokenUsed
+		}
+		return tx.Table("refresh_tokens").Create(next).Error
+	})
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token of a login and denies
+// the access tokens issued with them that have not expired yet
+func (r *AuthRepoImpl) RevokeRefreshTokenFamily(familyID string) error {
+	now := time.Now()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var tokens []domain.RefreshToken
+		if err := tx.Table("refresh_tokens").
+			Where("family_id = ? AND access_token_id <> '' AND access_token_expires_at > ?", familyID, now).
+			Find(&tokens).Error; err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			if err := revokeAccessToken(tx, t.AccessTokenID, t.AccessTokenExpiresAt); err != nil {
+				return err
+			}
+		}
+		return tx.Table("refresh_tokens").
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now).Error
+	})
+}
+
+func (r *AuthRepoImpl) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	return revokeAccessToken(r.db, jti, expiresAt)
+}
+
+func revokeAccessToken(db *gorm.DB, jti string, expiresAt time.Time) error {
+	return db.Table("revoked_tokens").
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&domain.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (r *AuthRepoImpl) IsAccessTokenRevoked(jti string) (bool, error) {
+	var count int64
+	if err := r.db.Table("revoked_tokens").Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteExpiredTokens removes denylist entries and refresh tokens that expired
+// before the given time, neither can be presented successfully any more
+func (r *AuthRepoImpl) DeleteExpiredTokens(before time.Time) (int64, error) {
+	var deleted int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("expires_at < ?", before).Delete(&domain.RevokedToken{})
+		if res.Error != nil {
+			return res.Error
+		}
+		deleted += res.RowsAffected
+		res = tx.Where("expires_at < ?", before).Delete(&domain.RefreshToken{})
+		if res.Error != nil {
+			return res.Error
+		}
+		deleted += res.RowsAffected
+		return nil
+	})
+	return deleted, err
+}
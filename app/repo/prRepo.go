@@ -4,22 +4,28 @@ import (
 	"errors"
 	"fmt"
 	"pr-mail/app/domain"
-	"pr-mail/app/dto"
+	"pr-mail/pkg/rbac"
 	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PrRepo interface {
 	GetUserByUsername(username string) (*domain.Credential, error)
-	UpdateUserToken(userID uint, token string) error
+	GetUserByID(userID uint) (*domain.Credential, error)
+	UpdateUserPassword(userID uint, passwordHash string) error
+	SaveAdminCredential(username, passwordHash string) (created bool, err error)
+	UpdateCredentialRole(username, role, staffID string) error
+	GetReportStaffIDs(managerEmpID string) ([]string, error)
 	GetEmployeeByEmpID(empID string) (*domain.Employee, error)
 	GetPRByEmpIDAndLink(employeeID uint, prLink string) (*domain.PullRequest, error)
 	UpdatePullRequest(pr *domain.PullRequest) error
 	SavePullRequest(pr *domain.PullRequest) error
 	ValidPRByEmpID(empID string) (*domain.PullRequest, error)
-	UpdatePRDetails(prID int64, data *dto.GitHubPRResponse) error
+	UpdatePRDetails(update *PRDetailsUpdate) error
+	UpdatePRStatus(pr *domain.PullRequest, transition *domain.PRStatusTransition) error
 	SavePRSnapshot(snapshot *domain.PRSnapshot) error
 	GetLatestSnapshot(prID uint) (*domain.PRSnapshot, error)
 	BuildReportFromSnapshot(pr *domain.PullRequest, snap *domain.PRSnapshot) string
@@ -32,6 +38,14 @@ type PrRepo interface {
 	ReportExistsForEmpAndDate(empID string, date time.Time) (bool, error)
 	ReportExistsForEmpAndDateAndPR(empID string, date time.Time, prLink string) (bool, error)
 	MarkReportsAsMailed(reportIDs []uint) error
+	GetSnapshotFiles(snapshotID uint) ([]domain.PRSnapshotFile, error)
+	GetSnapshotsByEmpIDAndDate(empID string, date time.Time) ([]domain.PRSnapshot, error)
+	GetPRCommitsSince(prID uint, since time.Time) ([]domain.PRCommit, error)
+	GetPreviousSnapshot(prID uint, before time.Time) (*domain.PRSnapshot, error)
+	GetPRsByRepoAndNumber(host, owner, repo string, prNumber int, prLink string) ([]domain.PullRequest, error)
+	GetEmployeesWithGitHubLogin() ([]domain.Employee, error)
+	GetEmployeeByGitHubLogin(login string) (*domain.Employee, error)
+	GetAllOpenOrDraftPRs() ([]domain.PullRequest, error)
 }
 
 type PrRepoImpl struct {
@@ -52,10 +66,70 @@ func (r *PrRepoImpl) GetUserByUsername(username string) (*domain.Credential, err
 	return &userDet, nil
 }
 
-func (r *PrRepoImpl) UpdateUserToken(userID uint, token string) error {
+func (r *PrRepoImpl) GetUserByID(userID uint) (*domain.Credential, error) {
+	var userDet domain.Credential
+	if err := r.db.Table("credentials").Where("id = ?", userID).First(&userDet).Error; err != nil {
+		return nil, err
+	}
+	return &userDet, nil
+}
+
+func (r *PrRepoImpl) UpdateUserPassword(userID uint, passwordHash string) error {
 	return r.db.Table("credentials").
 		Where("id = ?", userID).
-		Update("token", token).Error
+		Update("password", passwordHash).Error
+}
+
+// SaveAdminCredential sets the password of an admin, creating an active admin
+// when the username is new. Existing sessions of the admin end.
+func (r *PrRepoImpl) SaveAdminCredential(username, passwordHash string) (bool, error) {
+	var cred domain.Credential
+	err := r.db.Table("credentials").Where("username = ?", username).First(&cred).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		cred = domain.Credential{Username: username, Password: passwordHash, Status: true, Role: rbac.RoleAdmin}
+		return true, r.db.Table("credentials").Create(&cred).Error
+	}
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	return false, r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("credentials").
+			Where("id = ?", cred.ID).
+			Updates(map[string]interface{}{"password": passwordHash, "sessions_revoked_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.Table("refresh_tokens").
+			Where("admin_id = ? AND revoked_at IS NULL", cred.ID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// UpdateCredentialRole sets a credential's role and linked employee, it
+// returns gorm.ErrRecordNotFound for an unknown username
+func (r *PrRepoImpl) UpdateCredentialRole(username, role, staffID string) error {
+	res := r.db.Table("credentials").
+		Where("username = ?", username).
+		Updates(map[string]interface{}{"role": role, "staff_id": staffID})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetReportStaffIDs returns the EmpIDs of the employees reporting to a manager
+func (r *PrRepoImpl) GetReportStaffIDs(managerEmpID string) ([]string, error) {
+	var staffIDs []string
+	err := r.db.Table("employees").
+		Where("manager_emp_id = ?", managerEmpID).
+		Pluck("emp_id", &staffIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return staffIDs, nil
 }
 
 func (r *PrRepoImpl) GetEmployeeByEmpID(empID string) (*domain.Employee, error) {
@@ -70,7 +144,7 @@ func (r *PrRepoImpl) GetEmployeeByEmpID(empID string) (*domain.Employee, error)
 func (r *PrRepoImpl) GetPRByEmpIDAndLink(employeeID uint, prLink string) (*domain.PullRequest, error) {
 	var pr domain.PullRequest
 	err := r.db.Table("pull_requests").
-		Where("employee_id = ? AND pr_link = ?", employeeID, prLink).
+		Where("employee_id = ? AND LOWER(pr_link) = LOWER(?)", employeeID, prLink).
 		First(&pr).Error
 
 	if err != nil {
@@ -98,7 +172,7 @@ func (r *PrRepoImpl) ValidPRByEmpID(empID string) (*domain.PullRequest, error) {
 
 	var pr domain.PullRequest
 	err := r.db.
-		Where("employee_id = ? AND status IN ?", employee.ID, []string{"open", "draft"}).
+		Where("employee_id = ? AND status IN ?", employee.ID, []string{domain.PRStatusOpen, domain.PRStatusDraft}).
 		Order("updated_at DESC").
 		First(&pr).Error
 
@@ -109,20 +183,71 @@ func (r *PrRepoImpl) ValidPRByEmpID(empID string) (*domain.PullRequest, error) {
 	return &pr, nil
 }
 
-func (r *PrRepoImpl) UpdatePRDetails(prID int64, data *dto.GitHubPRResponse) error {
-	return r.db.Model(&domain.PullRequest{}).
-		Where("id = ?", prID).
-		Updates(map[string]interface{}{
-			"title":         data.Title,
-			"description":   data.Body,
-			"status":        data.State,
-			"files_changed": data.ChangedFiles,
-			"lines_added":   data.Additions,
-			"lines_removed": data.Deletions,
-			"commit_count":  data.Commits,
-			"branch_name":   data.Head.Ref,
-			"updated_at":    time.Now(),
-		}).Error
+// PRDetailsUpdate is everything written after a PR is fetched
+type PRDetailsUpdate struct {
+	PR       *domain.PullRequest
+	Snapshot *domain.PRSnapshot
+	// Files replace the snapshot's file list, nil leaves it as it is
+	Files   []domain.PRSnapshotFile
+	Commits []domain.PRCommit
+	// Transition is recorded when the fetch changed the PR's status
+	Transition *domain.PRStatusTransition
+}
+
+// UpdatePRDetails saves the day's snapshot with its files, the PR's commits,
+// any status transition and the PR row's latest state in one transaction
+func (r *PrRepoImpl) UpdatePRDetails(update *PRDetailsUpdate) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := savePRSnapshot(tx, update.Snapshot); err != nil {
+			return fmt.Errorf("save snapshot: %w", err)
+		}
+		if update.Files != nil {
+			if err := replaceSnapshotFiles(tx, update.Snapshot.ID, update.Files); err != nil {
+				return fmt.Errorf("save snapshot files: %w", err)
+			}
+		}
+		if len(update.Commits) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "pr_id"}, {Name: "sha"}},
+				DoNothing: true,
+			}).CreateInBatches(update.Commits, 250).Error
+			if err != nil {
+				return fmt.Errorf("save commits: %w", err)
+			}
+		}
+		if update.Transition != nil {
+			if err := tx.Create(update.Transition).Error; err != nil {
+				return fmt.Errorf("save status transition: %w", err)
+			}
+		}
+		// Only what the fetch sets is written, so columns changed meanwhile by
+		// someone else, such as AutoDiscovered cleared on save, are kept
+		return tx.Model(update.PR).Select(fetchedPRColumns).Updates(update.PR).Error
+	})
+}
+
+// fetchedPRColumns are the PullRequest columns a refresh sets from the fetched PR
+var fetchedPRColumns = []string{
+	"pr_link", "pr_number", "repo_owner", "repo_name", "title", "description",
+	"lines_added", "lines_removed", "files_changed", "commit_count",
+	"branch_name", "base_branch", "head_sha", "status", "is_draft", "merged_at", "closed_at",
+	"review_status", "reviewers", "e_tag", "last_modified", "status_e_tag",
+	"reviews_e_tag", "requested_reviewers_e_tag", "fetched_at", "updated_at",
+}
+
+// UpdatePRStatus saves a status recheck, only the PR's status columns and
+// title are written, along with the transition when there is one
+func (r *PrRepoImpl) UpdatePRStatus(pr *domain.PullRequest, transition *domain.PRStatusTransition) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if transition != nil {
+			if err := tx.Create(transition).Error; err != nil {
+				return fmt.Errorf("save status transition: %w", err)
+			}
+		}
+		return tx.Model(pr).
+			Select("status", "is_draft", "merged_at", "closed_at", "title", "status_e_tag").
+			Updates(pr).Error
+	})
 }
 
 // Fetch all PRSnapshots for an employee for today
@@ -143,18 +268,20 @@ func (r *PrRepoImpl) GetTodaySnapshotsByEmpID(empID string) ([]domain.PRSnapshot
 
 // Update SavePRSnapshot to avoid duplicate snapshot for same employee, PR, and date
 func (r *PrRepoImpl) SavePRSnapshot(snapshot *domain.PRSnapshot) error {
-	today := snapshot.Date.Truncate(24 * time.Hour)
-	var existing domain.PRSnapshot
-	err := r.db.Where("employee_id = ? AND pr_id = ? AND date = ?", snapshot.EmployeeID, snapshot.PRID, today).First(&existing).Error
-	if err == nil {
-		// Already exists, update it instead
-		snapshot.ID = existing.ID
-		return r.db.Model(&existing).Updates(snapshot).Error
-	} else if err != gorm.ErrRecordNotFound {
-		return err
-	}
-	// Not found, create new
-	return r.db.Create(snapshot).Error
+	return savePRSnapshot(r.db, snapshot)
+}
+
+// savePRSnapshot creates the PR's snapshot for the day or updates the existing
+// one. It's a single upsert on the (employee_id, pr_id, date) unique index, so
+// the hourly sync and a /pr/details call saving at the same time can't both
+// insert one. Every column but id and created_at is overwritten, zero values
+// like FilesTruncated=false included, and snapshot.ID is set to the row's ID.
+func savePRSnapshot(db *gorm.DB, snapshot *domain.PRSnapshot) error {
+	snapshot.Date = snapshot.Date.Truncate(24 * time.Hour)
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "employee_id"}, {Name: "pr_id"}, {Name: "date"}},
+		UpdateAll: true,
+	}).Create(snapshot).Error
 }
 
 func (r *PrRepoImpl) GetLatestSnapshot(prID uint) (*domain.PRSnapshot, error) {
@@ -184,20 +311,107 @@ Files Changed: %d
 Lines Added: %d  
 Lines Removed: %d  
 Commits: %d
-`,
+Review Status: %s
+Reviewers: %s
+CI: %s
+%s`,
 		snap.Name,
 		pr.EmployeeID,
 		pr.PRLink,
-		pr.BranchName,
+		branchText(pr),
 		strings.TrimSpace(pr.Title),
 		pr.Description,
 		snap.FilesChanged,
 		snap.LinesAdded,
 		snap.LinesRemoved,
 		snap.CommitCount,
+		reviewStatusText(snap.ReviewStatus),
+		reviewersText(snap.Reviewers),
+		ciText(snap.CIState, snap.FailingChecks),
+		r.newCommitsText(pr.ID, snap),
 	)
 }
 
+// branchText shows the source branch and, once known, the branch it merges into
+func branchText(pr *domain.PullRequest) string {
+	if pr.BaseBranch == "" {
+		return pr.BranchName
+	}
+	return pr.BranchName + " -> " + pr.BaseBranch
+}
+
+// newCommitsText lists the commits authored since the previous snapshot was
+// taken, or every stored commit when this is the PR's first snapshot
+func (r *PrRepoImpl) newCommitsText(prID uint, snap *domain.PRSnapshot) string {
+	var since time.Time
+	if prev, err := r.GetPreviousSnapshot(prID, snap.Date); err == nil {
+		since = prev.CreatedAt
+		if prev.UpdatedAt != nil {
+			since = *prev.UpdatedAt
+		}
+	}
+
+	commits, err := r.GetPRCommitsSince(prID, since)
+	if err != nil || len(commits) == 0 {
+		return "\nNew Commits: None\n"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("\nNew Commits (%d):\n", len(commits)))
+	for _, c := range commits {
+		author := c.AuthorLogin
+		if author == "" {
+			author = c.AuthorName
+		}
+		sha := c.SHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		sb.WriteString(fmt.Sprintf("- %s %s (%s, %s)\n", sha, c.Headline, author, c.AuthoredAt.Format("02 Jan 15:04")))
+	}
+	return sb.String()
+}
+
+// ciText turns a domain.CI* value into report wording, naming failing checks
+func ciText(state, failing string) string {
+	switch state {
+	case domain.CISuccess:
+		return "Passing"
+	case domain.CIPending:
+		return "Pending"
+	case domain.CIFailure:
+		if failing == "" {
+			return "Failing"
+		}
+		return "Failing (" + strings.ReplaceAll(failing, ",", ", ") + ")"
+	case domain.CINone:
+		return "No checks"
+	}
+	return "Unknown"
+}
+
+// reviewStatusText turns a domain.Review* value into report wording
+func reviewStatusText(status string) string {
+	switch status {
+	case domain.ReviewAwaiting:
+		return "Awaiting review"
+	case domain.ReviewChangesRequested:
+		return "Changes requested"
+	case domain.ReviewApproved:
+		return "Approved"
+	case domain.ReviewCommented:
+		return "Commented"
+	}
+	return "Unknown"
+}
+
+func reviewersText(reviewers string) string {
+	if reviewers == "" {
+		return "None"
+	}
+	return strings.ReplaceAll(reviewers, ",", ", ")
+}
+
 func (r *PrRepoImpl) SavePRReport(report *domain.PRReport) error {
 	return r.db.Create(report).Error
 }
@@ -237,7 +451,7 @@ func (r *PrRepoImpl) GetAllOpenOrDraftPRsByEmpID(empID string) ([]domain.PullReq
 
 	var prs []domain.PullRequest
 	err := r.db.
-		Where("employee_id = ? AND status IN ?", employee.ID, []string{"open", "draft"}).
+		Where("employee_id = ? AND status IN ?", employee.ID, []string{domain.PRStatusOpen, domain.PRStatusDraft}).
 		Order("updated_at DESC").
 		Find(&prs).Error
 
@@ -248,6 +462,19 @@ func (r *PrRepoImpl) GetAllOpenOrDraftPRsByEmpID(empID string) ([]domain.PullReq
 	return prs, nil
 }
 
+// GetAllOpenOrDraftPRs returns every open or draft PR across employees
+func (r *PrRepoImpl) GetAllOpenOrDraftPRs() ([]domain.PullRequest, error) {
+	var prs []domain.PullRequest
+	err := r.db.
+		Where("status IN ?", []string{domain.PRStatusOpen, domain.PRStatusDraft}).
+		Order("id").
+		Find(&prs).Error
+	if err != nil {
+		return nil, err
+	}
+	return prs, nil
+}
+
 // Fetch a PullRequest by its ID
 func (r *PrRepoImpl) GetPRByID(prID uint) (*domain.PullRequest, error) {
 	var pr domain.PullRequest
@@ -284,3 +511,105 @@ func (r *PrRepoImpl) ReportExistsForEmpAndDateAndPR(empID string, date time.Time
 func (r *PrRepoImpl) MarkReportsAsMailed(reportIDs []uint) error {
 	return r.db.Table("pr_reports").Where("id IN ?", reportIDs).Update("is_mail_sent", true).Error
 }
+
+// replaceSnapshotFiles replaces the file list of a snapshot, used when the same day is fetched again
+func replaceSnapshotFiles(tx *gorm.DB, snapshotID uint, files []domain.PRSnapshotFile) error {
+	if err := tx.Where("snapshot_id = ?", snapshotID).Delete(&domain.PRSnapshotFile{}).Error; err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	for i := range files {
+		files[i].SnapshotID = snapshotID
+	}
+	return tx.CreateInBatches(files, 500).Error
+}
+func (r *PrRepoImpl) GetSnapshotFiles(snapshotID uint) ([]domain.PRSnapshotFile, error) {
+	var files []domain.PRSnapshotFile
+	err := r.db.Where("snapshot_id = ?", snapshotID).Order("path").Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Fetch all PRSnapshots for an employee on the given day
+func (r *PrRepoImpl) GetSnapshotsByEmpIDAndDate(empID string, date time.Time) ([]domain.PRSnapshot, error) {
+	var employee domain.Employee
+	if err := r.db.Where("emp_id = ?", empID).First(&employee).Error; err != nil {
+		return nil, err
+	}
+
+	day := date.Truncate(24 * time.Hour)
+	var snaps []domain.PRSnapshot
+	err := r.db.Where("employee_id = ? AND date = ?", employee.ID, day).Find(&snaps).Error
+	if err != nil {
+		return nil, err
+	}
+	return snaps, nil
+}
+
+// Fetch a PR's commits authored after since, oldest first
+func (r *PrRepoImpl) GetPRCommitsSince(prID uint, since time.Time) ([]domain.PRCommit, error) {
+	var commits []domain.PRCommit
+	err := r.db.Where("pr_id = ? AND authored_at > ?", prID, since).
+		Order("authored_at").
+		Find(&commits).Error
+	if err != nil {
+		return nil, err
+	}
+	return commits, nil
+}
+
+// Fetch the latest snapshot of a PR taken on a day before the given date
+func (r *PrRepoImpl) GetPreviousSnapshot(prID uint, before time.Time) (*domain.PRSnapshot, error) {
+	var snap domain.PRSnapshot
+	err := r.db.
+		Where("pr_id = ? AND date < ?", prID, before.Truncate(24*time.Hour)).
+		Order("date DESC").
+		First(&snap).Error
+	if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Fetch every tracked PR (one per employee) matching a repository and number on host.
+// PRs that were never generated have no repo columns yet, so the link is matched too.
+func (r *PrRepoImpl) GetPRsByRepoAndNumber(host, owner, repo string, prNumber int, prLink string) ([]domain.PullRequest, error) {
+	var prs []domain.PullRequest
+	err := r.db.Table("pull_requests").
+		Where("(LOWER(repo_owner) = LOWER(?) AND LOWER(repo_name) = LOWER(?) AND pr_number = ? AND LOWER(pr_link) LIKE LOWER(?)) OR LOWER(pr_link) = LOWER(?)",
+			owner, repo, prNumber, "https://"+host+"/%", prLink).
+		Find(&prs).Error
+	if err != nil {
+		return nil, err
+	}
+	return prs, nil
+}
+
+// GetEmployeeByGitHubLogin returns the active employee with a GitHub login,
+// logins are case-insensitive
+func (r *PrRepoImpl) GetEmployeeByGitHubLogin(login string) (*domain.Employee, error) {
+	var emp domain.Employee
+	err := r.db.Table("employees").
+		Where("status = ? AND LOWER(github_login) = LOWER(?)", "active", login).
+		First(&emp).Error
+	if err != nil {
+		return nil, err
+	}
+	return &emp, nil
+}
+
+// GetEmployeesWithGitHubLogin returns active employees whose GitHub login is known
+func (r *PrRepoImpl) GetEmployeesWithGitHubLogin() ([]domain.Employee, error) {
+	var employees []domain.Employee
+	err := r.db.Table("employees").
+		Where("status = ? AND github_login <> ''", "active").
+		Find(&employees).Error
+	if err != nil {
+		return nil, err
+	}
+	return employees, nil
+}
@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"pr-mail/app/domain"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookRepo interface {
+	ClaimDelivery(delivery *domain.WebhookDelivery, staleAfter time.Duration) (bool, error)
+	MarkDelivery(deliveryID, status string) error
+}
+
+type WebhookRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepo(db *gorm.DB) WebhookRepo {
+	return &WebhookRepoImpl{
+		db: db,
+	}
+}
+
+// ClaimDelivery records a delivery as processing and reports whether this call
+// claimed it, i.e. whether the delivery should be processed. A delivery seen
+// before is claimed again only when it failed, or was left processing for
+// longer than staleAfter by a process that stopped. The unique delivery_id
+// makes concurrent redeliveries safe, only one of them claims it.
+func (r *WebhookRepoImpl) ClaimDelivery(delivery *domain.WebhookDelivery, staleAfter time.Duration) (bool, error) {
+	now := time.Now()
+	delivery.Status = domain.DeliveryProcessing
+	res := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "delivery_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"status": domain.DeliveryProcessing, "updated_at": now}),
+		Where: clause.Where{Exprs: []clause.Expression{clause.Expr{
+			SQL:  "webhook_deliveries.status = ? OR (webhook_deliveries.status = ? AND webhook_deliveries.updated_at < ?)",
+			Vars: []interface{}{domain.DeliveryFailed, domain.DeliveryProcessing, now.Add(-staleAfter)},
+		}}},
+	}).Create(delivery)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// MarkDelivery records how processing a claimed delivery ended
+func (r *WebhookRepoImpl) MarkDelivery(deliveryID, status string) error {
+	return r.db.Model(&domain.WebhookDelivery{}).
+		Where("delivery_id = ?", deliveryID).
+		Update("status", status).Error
+}
@@ -1,39 +1,75 @@
 package app
 
 import (
+	"net/http"
+	"os"
 	"pr-mail/app/controller"
 	"pr-mail/app/repo"
 	"pr-mail/app/service"
 
+	"pr-mail/pkg/api"
+	"pr-mail/pkg/jwt"
 	"pr-mail/pkg/middleware"
+	"pr-mail/pkg/rbac"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
-func APIRouter(db *gorm.DB) chi.Router {
+func APIRouter(db *gorm.DB, deps Deps) chi.Router {
 	r := chi.NewRouter()
 
 	// part
-	prRepo := repo.NewPrRepo(db)
-	prService := service.NewPrService(prRepo)
+	prRepo, providers, refresher := deps.prRepo, deps.providers, deps.refresher
+	jwtCfg, err := jwt.ConfigFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid JWT configuration")
+	}
+	tokens, err := jwt.NewTokenManager(jwtCfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid JWT configuration")
+	}
+	authRepo := repo.NewAuthRepo(db)
+	authService := service.NewAuthService(prRepo, authRepo, tokens, service.RefreshTokenTTLFromEnv())
+	authController := controller.NewAuthController(authService)
+	accessService := service.NewAccessService(prRepo)
+	prService := service.NewPrService(prRepo, refresher, providers, authService, accessService)
 	prController := controller.NewPrController(prService)
 
+	// webhooks
+	webhookRepo := repo.NewWebhookRepo(db)
+	webhookService := service.NewWebhookService(webhookRepo, prRepo, refresher, providers, os.Getenv("GITHUB_WEBHOOK_SECRET"))
+	webhookController := controller.NewWebhookController(webhookService)
+
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		api.Success(w, http.StatusOK, "ok")
+	})
+
+	// sessions, refresh is authenticated by the refresh token itself
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/refresh", authController.Refresh)
+		r.With(middleware.JWTAuthMiddleware(tokens, authService)).Post("/logout", authController.Logout)
+	})
+
 	//user
 	r.Route("/pr", func(r chi.Router) {
 		r.Post("/login", prController.Login)
-		r.Post("/save", prController.SaveEmployeePR)
-		r.Get("/details/{id}", prController.GeneratePRDetails)
-		r.Get("/report/{id}", prController.GeneratePRReport)
-		r.Post("/mail", prController.SendPRMail)
-
-		//r.With(middleware.JWTAuthMiddleware).Get("/hello", urController.ExampleHandler)
-	})
 
-	r.Group(func(r chi.Router) {
-		r.Use(middleware.JWTAuthMiddleware) // Applying JWT middleware
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.JWTAuthMiddleware(tokens, authService)) // Applying JWT middleware
 
+			// Which employees a caller may act for is checked by the access service
+			r.With(middleware.RequirePermission(rbac.SavePRs)).Post("/save", prController.SaveEmployeePR)
+			r.With(middleware.RequirePermission(rbac.ViewPRs)).Get("/details/{id}", prController.GeneratePRDetails)
+			r.With(middleware.RequirePermission(rbac.GenerateReports)).Get("/report/{id}", prController.GeneratePRReport)
+			r.With(middleware.RequirePermission(rbac.ViewPRs)).Get("/files/{id}", prController.GetPRFiles)
+			r.With(middleware.RequirePermission(rbac.SendMail)).Post("/mail", prController.SendPRMail)
+		})
 	})
 
+	// GitHub can't send a JWT, deliveries are authenticated by their signature instead
+	r.Post("/webhooks/github", webhookController.GitHubWebhook)
+
 	return r
 }
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	helper "pr-mail/app/helper"
+	"pr-mail/app/repo"
+	"pr-mail/pkg/e"
+	"pr-mail/pkg/rbac"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AccessService decides which employees a caller may act for. Admins may act
+// for everyone, managers for themselves and the employees reporting to them
+// and employees only for themselves. Route permissions are checked by
+// middleware.RequirePermission before this.
+type AccessService interface {
+	// Authorize fails with ErrForbidden unless every staff ID is in the caller's scope
+	Authorize(ctx context.Context, staffIDs ...string) error
+}
+
+type accessServiceImpl struct {
+	prRepo repo.PrRepo
+}
+
+func NewAccessService(prRepo repo.PrRepo) AccessService {
+	return &accessServiceImpl{
+		prRepo: prRepo,
+	}
+}
+
+func (s *accessServiceImpl) Authorize(ctx context.Context, staffIDs ...string) error {
+	// Set by JWTAuthMiddleware
+	claims, err := helper.GetClaimsFromContext(ctx)
+	if err != nil {
+		return e.NewError(e.ErrUnauthorized, "admin not authenticated", err)
+	}
+	if claims.Role == rbac.RoleAdmin {
+		log.Info().Msgf("admin %s is acting for %s", claims.Username, strings.Join(staffIDs, ", "))
+		return nil
+	}
+	if claims.StaffID == "" || !rbac.ValidRole(claims.Role) {
+		return e.NewError(e.ErrForbidden, fmt.Sprintf("%s %s is not linked to an employee", claims.Role, claims.Username), nil)
+	}
+
+	scope := map[string]bool{claims.StaffID: true}
+	if claims.Role == rbac.RoleManager {
+		reports, err := s.prRepo.GetReportStaffIDs(claims.StaffID)
+		if err != nil {
+			return e.NewError(e.ErrExecuteSQL, "failed to fetch manager's reports", err)
+		}
+		for _, staffID := range reports {
+			scope[staffID] = true
+		}
+	}
+
+	for _, staffID := range staffIDs {
+		if !scope[staffID] {
+			return e.NewError(e.ErrForbidden, fmt.Sprintf("not allowed to act for employee %s", staffID), nil)
+		}
+	}
+	log.Info().Msgf("%s %s is acting for %s", claims.Role, claims.Username, strings.Join(staffIDs, ", "))
+	return nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"pr-mail/app/repo"
+	"pr-mail/pkg/e"
+	"pr-mail/pkg/password"
+	"pr-mail/pkg/rbac"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// MinPasswordLength is the shortest admin password accepted
+const MinPasswordLength = 12
+
+// AdminService manages admin credentials outside of the HTTP API
+type AdminService interface {
+	// SetPassword hashes and stores an admin's password, creating the admin if needed
+	SetPassword(username, plain string) (created bool, err error)
+	// SetRole changes a credential's role, managers and employees are linked to
+	// the employee they log in as by staffID
+	SetRole(username, role, staffID string) error
+}
+
+type adminServiceImpl struct {
+	prRepo repo.PrRepo
+}
+
+func NewAdminService(prRepo repo.PrRepo) AdminService {
+	return &adminServiceImpl{
+		prRepo: prRepo,
+	}
+}
+
+func (s *adminServiceImpl) SetPassword(username, plain string) (bool, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return false, e.NewError(e.ErrValidateRequest, "username is required", nil)
+	}
+	if len(plain) < MinPasswordLength {
+		return false, e.NewError(e.ErrValidateRequest, fmt.Sprintf("password must be at least %d characters", MinPasswordLength), nil)
+	}
+
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return false, e.NewError(e.ErrValidateRequest, "password can't be hashed", err)
+	}
+
+	created, err := s.prRepo.SaveAdminCredential(username, hash)
+	if err != nil {
+		return false, e.NewError(e.ErrExecuteSQL, "failed to save admin password", err)
+	}
+	return created, nil
+}
+
+func (s *adminServiceImpl) SetRole(username, role, staffID string) error {
+	username, role, staffID = strings.TrimSpace(username), strings.ToLower(strings.TrimSpace(role)), strings.TrimSpace(staffID)
+	if !rbac.ValidRole(role) {
+		return e.NewError(e.ErrValidateRequest, fmt.Sprintf("unknown role %q", role), nil)
+	}
+	if role == rbac.RoleAdmin {
+		staffID = "" // admins act for everyone
+	} else {
+		if staffID == "" {
+			return e.NewError(e.ErrValidateRequest, fmt.Sprintf("a %s must be linked to a staff ID", role), nil)
+		}
+		if _, err := s.prRepo.GetEmployeeByEmpID(staffID); err != nil {
+			return e.NewError(e.ErrEmployeeNotValid, fmt.Sprintf("employee %s not found", staffID), err)
+		}
+	}
+
+	err := s.prRepo.UpdateCredentialRole(username, role, staffID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return e.NewError(e.ErrResourceNotFound, fmt.Sprintf("user %s not found", username), err)
+	}
+	if err != nil {
+		return e.NewError(e.ErrExecuteSQL, "failed to save role", err)
+	}
+	return nil
+}
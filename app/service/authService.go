@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"pr-mail/app/domain"
+	"pr-mail/app/dto"
+	helper "pr-mail/app/helper"
+	"pr-mail/app/repo"
+	"pr-mail/pkg/e"
+	"pr-mail/pkg/jwt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// DefaultRefreshTokenTTL is how long a refresh token stays usable, each
+// refresh issues a new one so an active admin stays logged in
+const DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+
+// RefreshTokenTTLFromEnv reads REFRESH_TOKEN_TTL, a Go duration
+func RefreshTokenTTLFromEnv() time.Duration {
+	if v := os.Getenv("REFRESH_TOKEN_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Warn().Msgf("Invalid REFRESH_TOKEN_TTL %q, using %s", v, DefaultRefreshTokenTTL)
+	}
+	return DefaultRefreshTokenTTL
+}
+
+// AuthService issues, refreshes and revokes admin sessions. A session is a
+// short lived access token plus a refresh token that rotates on every use.
+type AuthService interface {
+	StartSession(cred *domain.Credential) (*dto.LoginResponse, error)
+	RefreshSession(r *http.Request) (*dto.LoginResponse, error)
+	Logout(r *http.Request) error
+	CheckSession(ctx context.Context, claims *jwt.Claims) error
+}
+
+type authServiceImpl struct {
+	prRepo     repo.PrRepo
+	authRepo   repo.AuthRepo
+	tokens     jwt.TokenManager
+	refreshTTL time.Duration
+}
+
+func NewAuthService(prRepo repo.PrRepo, authRepo repo.AuthRepo, tokens jwt.TokenManager, refreshTTL time.Duration) AuthService {
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTokenTTL
+	}
+	return &authServiceImpl{
+		prRepo:     prRepo,
+		authRepo:   authRepo,
+		tokens:     tokens,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// StartSession issues the tokens for a login, starting a new refresh token family
+func (s *authServiceImpl) StartSession(cred *domain.Credential) (*dto.LoginResponse, error) {
+	familyID, err := randomToken()
+	if err != nil {
+		return nil, e.NewError(e.ErrTokenNotGenerated, "failed to generate token", err)
+	}
+	resp, next, err := s.issueTokens(cred, familyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authRepo.SaveRefreshToken(next); err != nil {
+		return nil, e.NewError(e.ErrTokenNotSaved, "failed to save refresh token", err)
+	}
+	return resp, nil
+}
+
+// RefreshSession trades a refresh token for a new access and refresh token. A
+// refresh token that was already used is treated as stolen, the family it
+// belongs to is revoked so neither the thief nor the admin can carry on with it.
+func (s *authServiceImpl) RefreshSession(r *http.Request) (*dto.LoginResponse, error) {
+	args := &dto.RefreshTokenRequest{}
+
+	err := args.Parse(r)
+	if err != nil {
+		return nil, e.NewError(e.ErrDecodeRequestBody, "error while parsing", err)
+	}
+	err = args.Validate()
+	if err != nil {
+		return nil, e.NewError(e.ErrValidateRequest, "error while validating", err)
+	}
+
+	current, err := s.authRepo.GetRefreshTokenByHash(hashToken(args.RefreshToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, e.NewError(e.ErrInvalidRefreshToken, "invalid refresh token", err)
+	}
+	if err != nil {
+		return nil, e.NewError(e.ErrExecuteSQL, "failed to fetch refresh token", err)
+	}
+
+	if current.UsedAt != nil || current.RevokedAt != nil {
+		return nil, s.refreshTokenReused(current)
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return nil, e.NewError(e.ErrInvalidRefreshToken, "refresh token expired", nil)
+	}
+
+	cred, err := s.prRepo.GetUserByID(current.AdminID)
+	if err != nil {
+		return nil, e.NewError(e.ErrInvalidRefreshToken, "admin not found", err)
+	}
+	if !cred.Status {
+		return nil, e.NewError(e.ErrAdminNotActive, "admin is not active", nil)
+	}
+
+	resp, next, err := s.issueTokens(cred, current.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authRepo.RotateRefreshToken(current, next)
+	if errors.Is(err, repo.ErrRefreshTokenUsed) {
+		// Another request used it between the lookup and now
+		return nil, s.refreshTokenReused(current)
+	}
+	if err != nil {
+		return nil, e.NewError(e.ErrTokenNotSaved, "failed to save refresh token", err)
+	}
+	log.Info().Msgf("Refreshed session of admin %s", cred.Username)
+	return resp, nil
+}
+
+func (s *authServiceImpl) refreshTokenReused(token *domain.RefreshToken) error {
+	log.Warn().Msgf("Refresh token reused for admin %d, revoking token family", token.AdminID)
+	if err := s.authRepo.RevokeRefreshTokenFamily(token.FamilyID); err != nil {
+		return e.NewError(e.ErrExecuteSQL, "failed to revoke refresh token family", err)
+	}
+	return e.NewError(e.ErrRefreshTokenReused, "refresh token was already used, please log in again", nil)
+}
+
+// Logout revokes the access token of the request and the refresh token family
+// it was issued with
+func (s *authServiceImpl) Logout(r *http.Request) error {
+	// Set by JWTAuthMiddleware
+	claims, err := helper.GetClaimsFromContext(r.Context())
+	if err != nil {
+		return e.NewError(e.ErrUnauthorized, "admin not authenticated", err)
+	}
+	log.Info().Msgf("Admin %d is logging out", claims.AdminID)
+
+	err = s.authRepo.RevokeAccessToken(claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return e.NewError(e.ErrExecuteSQL, "failed to revoke token", err)
+	}
+
+	token, err := s.authRepo.GetRefreshTokenByAccessTokenID(claims.Id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // issued before refresh tokens, there is no family to revoke
+	}
+	if err != nil {
+		return e.NewError(e.ErrExecuteSQL, "failed to fetch refresh token", err)
+	}
+	if err := s.authRepo.RevokeRefreshTokenFamily(token.FamilyID); err != nil {
+		return e.NewError(e.ErrExecuteSQL, "failed to revoke refresh token family", err)
+	}
+	return nil
+}
+
+// CheckSession accepts a validly signed access token while the admin is
+// active, the token is not revoked, it was issued after the admin's sessions
+// were last ended by a password change and its role is still the admin's
+func (s *authServiceImpl) CheckSession(ctx context.Context, claims *jwt.Claims) error {
+	if claims.AdminID <= 0 {
+		return e.NewError(e.ErrUnauthorized, "invalid admin", nil)
+	}
+	cred, err := s.prRepo.GetUserByID(uint(claims.AdminID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return e.NewError(e.ErrUnauthorized, "admin not found", err)
+	}
+	if err != nil {
+		return e.NewError(e.ErrExecuteSQL, "failed to fetch admin", err)
+	}
+	if !cred.Status {
+		return e.NewError(e.ErrAdminNotActive, "admin is not active", nil)
+	}
+	if cred.SessionsRevokedAt != nil && claims.IssuedAt < cred.SessionsRevokedAt.Unix() {
+		return e.NewError(e.ErrUnauthorized, "token was issued before the password changed", nil)
+	}
+	// A refresh picks up the new role, until then the old one must not be used
+	if claims.Role != cred.Role || claims.StaffID != cred.StaffID {
+		return e.NewError(e.ErrUnauthorized, "role has changed since the token was issued", nil)
+	}
+
+	revoked, err := s.authRepo.IsAccessTokenRevoked(claims.Id)
+	if err != nil {
+		return e.NewError(e.ErrExecuteSQL, "failed to check token revocation", err)
+	}
+	if revoked {
+		return e.NewError(e.ErrUnauthorized, "token has been revoked", nil)
+	}
+	return nil
+}
+
+// issueTokens generates an access token and the refresh token that goes with
+// it, returning the refresh token row for the caller to store
+func (s *authServiceImpl) issueTokens(cred *domain.Credential, familyID string) (*dto.LoginResponse, *domain.RefreshToken, error) {
+	accessToken, claims, err := s.tokens.GenerateToken(int64(cred.ID), cred.Username, cred.Role, cred.StaffID)
+	if err != nil {
+		return nil, nil, e.NewError(e.ErrTokenNotGenerated, "failed to generate token", err)
+	}
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, nil, e.NewError(e.ErrTokenNotGenerated, "failed to generate refresh token", err)
+	}
+
+	accessExpiresAt := time.Unix(claims.ExpiresAt, 0)
+	next := &domain.RefreshToken{
+		AdminID:              cred.ID,
+		FamilyID:             familyID,
+		TokenHash:            hashToken(refreshToken),
+		AccessTokenID:        claims.Id,
+		AccessTokenExpiresAt: accessExpiresAt,
+		ExpiresAt:            time.Now().Add(s.refreshTTL),
+	}
+	return &dto.LoginResponse{
+		Token:            accessToken,
+		TokenExpiresAt:   accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: next.ExpiresAt,
+	}, next, nil
+}
+
+// randomToken returns 256 random bits, URL safe
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what refresh tokens are stored and looked up by, a plain
+// sha256 is enough for 256 bit random tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
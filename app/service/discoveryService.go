@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"pr-mail/app/domain"
+	"pr-mail/app/dto"
+	github "pr-mail/app/github"
+	helper "pr-mail/app/helper"
+	"pr-mail/app/repo"
+	"pr-mail/pkg/e"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// DefaultDiscoveryInterval is how often the discovery job runs when enabled
+const DefaultDiscoveryInterval = 6 * time.Hour
+
+type DiscoveryConfig struct {
+	// Orgs are the GitHub organizations searched, discovery is off without any
+	Orgs []string
+	// Interval between scheduled runs
+	Interval time.Duration
+}
+
+// DiscoveryConfigFromEnv builds a DiscoveryConfig from GITHUB_DISCOVERY_ORGS
+// (comma separated) and GITHUB_DISCOVERY_INTERVAL (a Go duration such as 6h)
+func DiscoveryConfigFromEnv() DiscoveryConfig {
+	cfg := DiscoveryConfig{Interval: DefaultDiscoveryInterval}
+	for _, org := range strings.Split(os.Getenv("GITHUB_DISCOVERY_ORGS"), ",") {
+		if org = strings.TrimSpace(org); org != "" {
+			cfg.Orgs = append(cfg.Orgs, org)
+		}
+	}
+	if v := os.Getenv("GITHUB_DISCOVERY_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.Interval = d
+		}
+	}
+	return cfg
+}
+
+// DiscoveryService finds open PRs authored by employees that nobody saved and
+// tracks them, flagged as auto-discovered so they can be reviewed
+type DiscoveryService interface {
+	DiscoverPRs(ctx context.Context) (*dto.DiscoveryResponse, error)
+}
+
+type discoveryServiceImpl struct {
+	prRepo   repo.PrRepo
+	ghClient github.GitHubClient
+	cfg      DiscoveryConfig
+}
+
+func NewDiscoveryService(prRepo repo.PrRepo, ghClient github.GitHubClient, cfg DiscoveryConfig) DiscoveryService {
+	return &discoveryServiceImpl{
+		prRepo:   prRepo,
+		ghClient: ghClient,
+		cfg:      cfg,
+	}
+}
+
+func (s *discoveryServiceImpl) DiscoverPRs(ctx context.Context) (*dto.DiscoveryResponse, error) {
+	result := &dto.DiscoveryResponse{Created: []string{}}
+	if len(s.cfg.Orgs) == 0 {
+		return result, nil
+	}
+
+	employees, err := s.prRepo.GetEmployeesWithGitHubLogin()
+	if err != nil {
+		return nil, e.NewError(e.ErrExecuteSQL, "failed to load employees", err)
+	}
+
+	for _, emp := range employees {
+		for _, org := range s.cfg.Orgs {
+			found, err := s.ghClient.SearchOpenPRs(ctx, org, emp.GitHubLogin)
+			if err != nil {
+				if errors.Is(err, github.ErrRateLimited) || ctx.Err() != nil {
+					// Later searches would fail the same way, the next run picks them up
+					return nil, e.NewError(e.ErrGitHubAPI, "PR discovery stopped", err)
+				}
+				log.Warn().Err(err).Msgf("PR search failed for %s in %s", emp.GitHubLogin, org)
+				result.Failures = append(result.Failures, dto.DiscoveryFailure{
+					StaffID: emp.EmpID,
+					Login:   emp.GitHubLogin,
+					Org:     org,
+					Message: err.Error(),
+				})
+				continue
+			}
+
+			for _, item := range found {
+				created, err := s.trackPR(&emp, item)
+				if err != nil {
+					log.Error().Err(err).Msgf("Failed to track %s for %s", item.HTMLURL, emp.EmpID)
+					result.Failures = append(result.Failures, dto.DiscoveryFailure{
+						StaffID: emp.EmpID,
+						Login:   emp.GitHubLogin,
+						Org:     org,
+						PRLink:  item.HTMLURL,
+						Message: err.Error(),
+					})
+					continue
+				}
+				if created == "" {
+					result.Existing++
+					continue
+				}
+				result.Created = append(result.Created, created)
+			}
+		}
+	}
+
+	log.Info().Msgf("PR discovery added %d PRs, %d already tracked", len(result.Created), result.Existing)
+	return result, nil
+}
+
+// trackPR saves a PR found by search unless the employee already has it,
+// returning its link when a row was created
+func (s *discoveryServiceImpl) trackPR(emp *domain.Employee, item dto.GitHubSearchPR) (string, error) {
+	ref, err := helper.ParseGitHubPRRef(item.HTMLURL, s.ghClient.WebHost())
+	if err != nil {
+		log.Warn().Err(err).Msgf("Skipping search result with unexpected link %s", item.HTMLURL)
+		return "", nil
+	}
+	link := ref.URL()
+
+	existing, err := s.prRepo.GetPRByEmpIDAndLink(emp.ID, link)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", e.NewError(e.ErrPrCheckingFailed, "failed to check existing PR", err)
+	}
+	if existing != nil {
+		return "", nil
+	}
+
+	status := domain.PRStatusOpen
+	if item.Draft {
+		status = domain.PRStatusDraft
+	}
+	now := time.Now()
+	pr := &domain.PullRequest{
+		EmployeeID:     emp.ID,
+		StaffID:        emp.EmpID,
+		PRLink:         link,
+		RepoOwner:      ref.Owner,
+		RepoName:       ref.Repo,
+		PRNumber:       ref.Number,
+		Title:          item.Title,
+		Status:         status,
+		IsDraft:        item.Draft,
+		AutoDiscovered: true,
+		CreatedAt:      &now,
+		UpdatedAt:      &now,
+	}
+	if err := s.prRepo.SavePullRequest(pr); err != nil {
+		return "", e.NewError(e.ErrSavePr, "failed to save discovered PR", err)
+	}
+	log.Info().Msgf("Discovered PR %s for %s", link, emp.EmpID)
+	return link, nil
+}
@@ -0,0 +1,659 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"pr-mail/app/domain"
+	"pr-mail/app/dto"
+	github "pr-mail/app/github"
+	helper "pr-mail/app/helper"
+	"pr-mail/app/provider"
+	"pr-mail/app/repo"
+	"pr-mail/pkg/e"
+	"pr-mail/pkg/pool"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Config struct {
+	// FetchConcurrency is the maximum number of PRs fetched from GitHub at once
+	FetchConcurrency int
+}
+
+// ConfigFromEnv builds a Config from PR_FETCH_CONCURRENCY
+func ConfigFromEnv() Config {
+	cfg := Config{FetchConcurrency: pool.DefaultConcurrency}
+	if n, err := strconv.Atoi(os.Getenv("PR_FETCH_CONCURRENCY")); err == nil && n > 0 {
+		cfg.FetchConcurrency = n
+	}
+	return cfg
+}
+
+// PRRefresher fetches tracked PRs from GitHub and stores their latest state
+// along with today's snapshot. It is shared by the generate endpoint and the
+// webhook receiver so both write exactly the same data.
+type PRRefresher interface {
+	RefreshPRs(ctx context.Context, prs []domain.PullRequest) (*dto.PRDetailsResponse, error)
+	// RecheckStatuses fetches only the PRs themselves, conditionally on GitHub,
+	// and stores changes to their status, merged/closed times and title.
+	// Snapshots, reviews, CI, files and commits are left to RefreshPRs.
+	RecheckStatuses(ctx context.Context, prs []domain.PullRequest) (*dto.StatusSyncResponse, error)
+}
+
+type prRefresherImpl struct {
+	prRepo    repo.PrRepo
+	ghClient  github.GitHubClient
+	providers *provider.Registry
+	cfg       Config
+}
+
+// fetchedPR is the outcome of fetching a single PR from GitHub
+type fetchedPR struct {
+	owner    string
+	repo     string
+	prNumber int
+	data     *dto.GitHubPRResponse
+
+	reviewStatus     string
+	reviewers        []string
+	reviewValidators github.ReviewValidators // empty unless reviews came from the REST API
+	ciState          string
+	failingChecks    []string
+
+	files          []dto.GitHubPRFile
+	filesTruncated bool
+	hasFiles       bool
+
+	commits []domain.PRCommit
+
+	// link is the canonical form of the PR's link, stored in place of older spellings
+	link string
+}
+
+// NewPRRefresher fetches GitHub links with ghClient and any other host through providers
+func NewPRRefresher(prRepo repo.PrRepo, ghClient github.GitHubClient, providers *provider.Registry, cfg Config) PRRefresher {
+	return &prRefresherImpl{
+		prRepo:    prRepo,
+		ghClient:  ghClient,
+		providers: providers,
+		cfg:       cfg,
+	}
+}
+
+// RefreshPRs fetches prs concurrently and stores each one that succeeded.
+// Failures to fetch or save are reported per PR, only a rate limit that left nothing fetched
+// is returned as an error.
+func (s *prRefresherImpl) RefreshPRs(ctx context.Context, prs []domain.PullRequest) (*dto.PRDetailsResponse, error) {
+	// In GraphQL mode most of each PR comes back from a few batched queries up front
+	batched := s.prefetchBatch(ctx, prs)
+
+	// Fetch every PR from GitHub concurrently, results come back in the same order as prs
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := pool.Map(fetchCtx, s.cfg.FetchConcurrency, prs, func(fetchCtx context.Context, pr domain.PullRequest) (*fetchedPR, error) {
+		fetched, err := s.fetchPR(fetchCtx, &pr, batched[pr.ID])
+		if errors.Is(err, github.ErrRateLimited) {
+			// Every remaining PR would hit the same limit, so stop the others
+			cancel()
+		}
+		return fetched, err
+	})
+
+	prDetailsList := []dto.SinglePRDetails{}
+	failures := []dto.PRFetchFailure{}
+	var rateLimitErr error
+	for i, pr := range prs {
+		res := results[i]
+		if res.Err != nil {
+			log.Error().Err(res.Err).Msgf("Failed to fetch PR data from GitHub for PR: %s", pr.PRLink)
+			if errors.Is(res.Err, github.ErrRateLimited) {
+				rateLimitErr = res.Err
+			}
+			failures = append(failures, fetchFailure(ctx, fetchCtx, pr, res.Err))
+			continue // report and skip this PR
+		}
+
+		details, err := s.storePR(&pr, res.Value)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to save fetched details for PR: %s", pr.PRLink)
+			failures = append(failures, dto.PRFetchFailure{
+				PRLink:   pr.PRLink,
+				Category: dto.FailureStore,
+				Message:  err.Error(),
+			})
+			continue
+		}
+		prDetailsList = append(prDetailsList, details)
+	}
+
+	if len(prDetailsList) == 0 && rateLimitErr != nil {
+		return nil, e.NewError(e.ErrGitHubAPI, "GitHub rate limit exhausted", rateLimitErr)
+	}
+	if len(failures) > 0 {
+		log.Warn().Msgf("Fetched %d of %d PRs, %d failed", len(prDetailsList), len(prs), len(failures))
+	}
+
+	return &dto.PRDetailsResponse{
+		PRs:      prDetailsList,
+		Failures: failures,
+	}, nil
+}
+
+func (s *prRefresherImpl) RecheckStatuses(ctx context.Context, prs []domain.PullRequest) (*dto.StatusSyncResponse, error) {
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := pool.Map(fetchCtx, s.cfg.FetchConcurrency, prs, func(fetchCtx context.Context, pr domain.PullRequest) (*dto.GitHubPRResponse, error) {
+		data, err := s.fetchPRState(fetchCtx, &pr)
+		if errors.Is(err, github.ErrRateLimited) {
+			cancel()
+		}
+		return data, err
+	})
+
+	result := &dto.StatusSyncResponse{
+		Checked:  len(prs),
+		Changed:  []dto.PRStatusChange{},
+		Failures: []dto.PRFetchFailure{},
+	}
+	var rateLimitErr error
+	for i, pr := range prs {
+		res := results[i]
+		if res.Err != nil {
+			log.Error().Err(res.Err).Msgf("Failed to recheck status of PR: %s", pr.PRLink)
+			if errors.Is(res.Err, github.ErrRateLimited) {
+				rateLimitErr = res.Err
+			}
+			result.Failures = append(result.Failures, fetchFailure(ctx, fetchCtx, pr, res.Err))
+			continue
+		}
+		if res.Value == nil {
+			continue // not modified since the last check
+		}
+
+		from := pr.Status
+		transition := applyPRStatus(&pr, res.Value)
+		pr.Title = res.Value.Title
+		pr.StatusETag = res.Value.ETag
+		if err := s.prRepo.UpdatePRStatus(&pr, transition); err != nil {
+			log.Error().Err(err).Msgf("Failed to save status of PR: %s", pr.PRLink)
+			result.Failures = append(result.Failures, dto.PRFetchFailure{
+				PRLink:   pr.PRLink,
+				Category: dto.FailureStore,
+				Message:  err.Error(),
+			})
+			continue
+		}
+		if transition != nil {
+			result.Changed = append(result.Changed, dto.PRStatusChange{
+				PRID:       pr.ID,
+				PRLink:     pr.PRLink,
+				FromStatus: from,
+				ToStatus:   pr.Status,
+			})
+		}
+	}
+
+	if rateLimitErr != nil && len(result.Failures) == len(prs) {
+		return nil, e.NewError(e.ErrGitHubAPI, "GitHub rate limit exhausted", rateLimitErr)
+	}
+	return result, nil
+}
+
+// fetchPRState fetches only the PR itself for a status recheck. GitHub is
+// asked conditionally, nil is returned when it answers 304.
+func (s *prRefresherImpl) fetchPRState(ctx context.Context, pr *domain.PullRequest) (*dto.GitHubPRResponse, error) {
+	if p, ref, ok := s.providers.Match(pr.PRLink); ok {
+		return p.FetchPRState(ctx, ref)
+	}
+	ref, err := helper.ParseGitHubPRRef(pr.PRLink, s.ghClient.WebHost())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidPRLink, err)
+	}
+
+	// The full fetch's ETag matches the stored status until the first recheck
+	etag := pr.StatusETag
+	if etag == "" {
+		etag = pr.ETag
+	}
+	data, err := s.ghClient.FetchPRDetailsIfChanged(ctx, ref.Owner, ref.Repo, ref.Number, github.CacheValidators{ETag: etag})
+	if errors.Is(err, github.ErrNotModified) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// fetchFailure reports a PR that couldn't be fetched. fetchCtx is the context
+// cancelled once a PR hits the rate limit.
+func fetchFailure(ctx, fetchCtx context.Context, pr domain.PullRequest, err error) dto.PRFetchFailure {
+	category := classifyFetchError(err)
+	if !errors.Is(err, github.ErrRateLimited) && errors.Is(err, context.Canceled) && fetchCtx.Err() != nil && ctx.Err() == nil {
+		// Cancelled by us after another PR hit the rate limit
+		category = dto.FailureRateLimited
+	}
+	return dto.PRFetchFailure{
+		PRLink:   pr.PRLink,
+		Category: category,
+		Message:  err.Error(),
+	}
+}
+
+// prefetchBatch fetches prs through the client's batch API when it has one,
+// keyed by PR ID. A PR whose query failed carries the error, which fetchPR
+// reports, and if the batch fails as a whole every PR falls back to REST.
+func (s *prRefresherImpl) prefetchBatch(ctx context.Context, prs []domain.PullRequest) map[uint]*github.BatchResult {
+	batched := map[uint]*github.BatchResult{}
+	batcher, ok := s.ghClient.(github.BatchFetcher)
+	if !ok {
+		return batched
+	}
+
+	var refs []github.BatchRef
+	var ids []uint
+	for _, pr := range prs {
+		if _, _, ok := s.providers.Match(pr.PRLink); ok {
+			continue // not on GitHub
+		}
+		ref, err := helper.ParseGitHubPRRef(pr.PRLink, s.ghClient.WebHost())
+		if err != nil {
+			continue // reported as a bad link by fetchPR
+		}
+		refs = append(refs, github.BatchRef{Owner: ref.Owner, Repo: ref.Repo, Number: ref.Number})
+		ids = append(ids, pr.ID)
+	}
+	if len(refs) == 0 {
+		return batched
+	}
+
+	results, err := batcher.FetchPRBatch(ctx, refs)
+	if err != nil {
+		log.Error().Err(err).Msg("GraphQL batch fetch failed, falling back to REST")
+		return batched
+	}
+	for i := range results {
+		batched[ids[i]] = &results[i]
+	}
+	return batched
+}
+
+// fetchPR loads everything GitHub knows about a single PR. Only the PR itself
+// is required, reviews, files, commits and CI are best effort unless rate limited.
+// Whatever batched already holds is used instead of calling the REST API.
+func (s *prRefresherImpl) fetchPR(ctx context.Context, pr *domain.PullRequest, batched *github.BatchResult) (*fetchedPR, error) {
+	if p, ref, ok := s.providers.Match(pr.PRLink); ok {
+		return s.fetchFromProvider(ctx, pr, p, ref)
+	}
+
+	// Extract repo owner/name and PR number from pr.PRLink, the client can
+	// only fetch links on the server it is configured for
+	ref, err := helper.ParseGitHubPRRef(pr.PRLink, s.ghClient.WebHost())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidPRLink, err)
+	}
+	owner, repo, prNumber := ref.Owner, ref.Repo, ref.Number
+	log.Info().Msgf("Processing PR: owner=%s, repo=%s, prNumber=%d", owner, repo, prNumber)
+
+	// GitHub API to fetch PR details, skipping the download when nothing changed
+	var prData *dto.GitHubPRResponse
+	if batched != nil {
+		prData, err = batched.PR, batched.Err
+	} else {
+		prData, err = s.fetchPRData(ctx, pr, owner, repo, prNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fetched := &fetchedPR{owner: owner, repo: repo, prNumber: prNumber, data: prData, link: ref.URL()}
+
+	// Reviews can be submitted or dismissed without the PR's ETag changing, so
+	// they're checked even on 304, with their own conditional requests
+	storedReviews := github.ReviewValidators{Reviews: pr.ReviewsETag, Requested: pr.RequestedReviewersETag}
+	if batched != nil && batched.Reviews != nil {
+		fetched.reviewStatus, fetched.reviewers = github.ReviewStatus(batched.Reviews)
+	} else if reviews, validators, err := s.ghClient.FetchPRReviewsIfChanged(ctx, owner, repo, prNumber, storedReviews); err == nil {
+		fetched.reviewStatus, fetched.reviewers = github.ReviewStatus(reviews)
+		fetched.reviewValidators = validators
+	} else if errors.Is(err, github.ErrNotModified) {
+		fetched.reviewStatus = pr.ReviewStatus
+		fetched.reviewers = splitList(pr.Reviewers)
+		fetched.reviewValidators = storedReviews
+	} else if errors.Is(err, github.ErrRateLimited) {
+		return nil, err
+	} else {
+		// Keep the last known review state rather than blanking it
+		log.Warn().Err(err).Msgf("Failed to fetch reviews for PR: %s", pr.PRLink)
+		fetched.reviewStatus = pr.ReviewStatus
+		fetched.reviewers = splitList(pr.Reviewers)
+		fetched.reviewValidators = storedReviews
+	}
+
+	// The file list only changes with the PR, on 304 the previous snapshot's list is copied
+	if batched != nil && batched.Files != nil {
+		fetched.files, fetched.hasFiles = batched.Files, true
+	} else if !prData.NotModified {
+		if files, err := s.ghClient.FetchPRFiles(ctx, owner, repo, prNumber); err == nil {
+			// A PR with more files than GitHub lists has a changed_files above the count
+			fetched.files, fetched.hasFiles = files, true
+			fetched.filesTruncated = prData.ChangedFiles > len(files)
+		} else if errors.Is(err, github.ErrRateLimited) {
+			return nil, err
+		} else {
+			log.Warn().Err(err).Msgf("Failed to fetch changed files for PR: %s", pr.PRLink)
+		}
+	}
+
+	// New commits change the PR's ETag, so there is nothing new to store on 304
+	if batched != nil && batched.Commits != nil {
+		fetched.commits = prCommits(pr.ID, batched.Commits)
+	} else if !prData.NotModified {
+		if commits, err := s.ghClient.FetchPRCommits(ctx, owner, repo, prNumber); err == nil {
+			fetched.commits = prCommits(pr.ID, commits)
+		} else if errors.Is(err, github.ErrRateLimited) {
+			return nil, err
+		} else {
+			log.Warn().Err(err).Msgf("Failed to fetch commits for PR: %s", pr.PRLink)
+		}
+	}
+
+	// Checks keep running after the last push, so always ask for the head commit's CI state
+	if batched != nil && batched.CI != nil {
+		fetched.ciState, fetched.failingChecks = github.CIStatus(batched.CI)
+	} else if sha := prData.Head.SHA; sha != "" {
+		if ci, err := s.ghClient.FetchCIStatus(ctx, owner, repo, sha); err == nil {
+			fetched.ciState, fetched.failingChecks = github.CIStatus(ci)
+		} else if errors.Is(err, github.ErrRateLimited) {
+			return nil, err
+		} else {
+			// Keep the last known CI state rather than blanking it
+			log.Warn().Err(err).Msgf("Failed to fetch CI status for PR: %s", pr.PRLink)
+			if prev, err := s.prRepo.GetLatestSnapshot(pr.ID); err == nil {
+				fetched.ciState, fetched.failingChecks = prev.CIState, splitList(prev.FailingChecks)
+			}
+		}
+	}
+	return fetched, nil
+}
+
+// fetchFromProvider loads a PR from a non-GitHub host, which returns everything in one go
+func (s *prRefresherImpl) fetchFromProvider(ctx context.Context, pr *domain.PullRequest, p provider.Provider, ref helper.PRRef) (*fetchedPR, error) {
+	log.Info().Msgf("Processing %s PR: owner=%s, repo=%s, prNumber=%d", p.Name(), ref.Owner, ref.Repo, ref.Number)
+
+	data, err := p.FetchPR(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	fetched := &fetchedPR{
+		owner:         ref.Owner,
+		repo:          ref.Repo,
+		prNumber:      ref.Number,
+		data:          data.PR,
+		reviewStatus:  data.ReviewStatus,
+		reviewers:     data.Reviewers,
+		ciState:       data.CIState,
+		failingChecks: data.FailingChecks,
+		link:          p.Link(ref),
+	}
+	if data.Files != nil {
+		fetched.files, fetched.hasFiles = data.Files, true
+		fetched.filesTruncated = data.FilesTruncated || data.PR.ChangedFiles > len(data.Files)
+	}
+	if data.Commits != nil {
+		fetched.commits = prCommits(pr.ID, data.Commits)
+	}
+	return fetched, nil
+}
+
+// storePR writes today's snapshot, its files and commits, then updates the PR
+// row, all in one transaction. Nothing is saved when it returns an error.
+func (s *prRefresherImpl) storePR(pr *domain.PullRequest, fetched *fetchedPR) (dto.SinglePRDetails, error) {
+	owner, repoName, prNumber, prData := fetched.owner, fetched.repo, fetched.prNumber, fetched.data
+	reviewers := strings.Join(fetched.reviewers, ",")
+
+	// On 304 carry the file list over from the previous snapshot before today's replaces it
+	var files []domain.PRSnapshotFile
+	filesTruncated := fetched.filesTruncated
+	if fetched.hasFiles {
+		files = snapshotFiles(pr.ID, fetched.files)
+	} else if prData.NotModified {
+		if prev, err := s.prRepo.GetLatestSnapshot(pr.ID); err == nil {
+			filesTruncated = prev.FilesTruncated
+			if files, err = s.prRepo.GetSnapshotFiles(prev.ID); err != nil {
+				log.Error().Err(err).Msgf("Failed to load previous file list for PR: %s", pr.PRLink)
+			}
+		}
+	}
+
+	// Save today's PR data to a table so we can see the daily change here
+	snapshot := &domain.PRSnapshot{
+		EmployeeID:     pr.EmployeeID,
+		Name:           owner,
+		PRID:           pr.ID,
+		Date:           time.Now().Truncate(24 * time.Hour),
+		Description:    prData.Body, // Use PR description from GitHub
+		LinesAdded:     prData.Additions,
+		LinesRemoved:   prData.Deletions,
+		FilesChanged:   prData.ChangedFiles,
+		CommitCount:    prData.Commits,
+		ReviewStatus:   fetched.reviewStatus,
+		Reviewers:      reviewers,
+		CIState:        fetched.ciState,
+		FailingChecks:  strings.Join(fetched.failingChecks, ","),
+		FilesTruncated: filesTruncated,
+	}
+	for i := range files {
+		files[i].ID = 0 // copied rows are inserted again for today's snapshot
+	}
+
+	// Keep the PR row's aggregate columns current, the report reads them
+	if fetched.link != "" {
+		pr.PRLink = fetched.link
+	}
+	pr.PRNumber = prNumber
+	pr.RepoOwner = owner
+	pr.RepoName = repoName
+	pr.Title = prData.Title
+	pr.Description = prData.Body
+	pr.LinesAdded = prData.Additions
+	pr.LinesRemoved = prData.Deletions
+	pr.FilesChanged = prData.ChangedFiles
+	pr.CommitCount = prData.Commits
+	pr.BranchName = prData.Head.Ref
+	pr.BaseBranch = prData.Base.Ref
+	pr.HeadSHA = prData.Head.SHA
+	pr.ETag = prData.ETag
+	pr.LastModified = prData.LastModified
+	pr.StatusETag = prData.ETag
+	pr.ReviewStatus = fetched.reviewStatus
+	pr.Reviewers = reviewers
+	pr.ReviewsETag = fetched.reviewValidators.Reviews
+	pr.RequestedReviewersETag = fetched.reviewValidators.Requested
+	pr.FetchedAt = time.Now()
+	transition := applyPRStatus(pr, prData)
+	status := pr.Status
+
+	err := s.prRepo.UpdatePRDetails(&repo.PRDetailsUpdate{
+		PR:         pr,
+		Snapshot:   snapshot,
+		Files:      files,
+		Commits:    fetched.commits,
+		Transition: transition,
+	})
+	if err != nil {
+		return dto.SinglePRDetails{}, e.NewError(e.ErrUpdatingPRDetails, "failed to save PR details", err)
+	}
+
+	return dto.SinglePRDetails{
+		PRID:           pr.ID,
+		Owner:          owner,
+		Title:          prData.Title,
+		Description:    prData.Body,
+		Status:         status,
+		IsMerged:       status == domain.PRStatusMerged,
+		Files:          prData.ChangedFiles,
+		LinesAdded:     prData.Additions,
+		LinesRemoved:   prData.Deletions,
+		CommitCount:    prData.Commits,
+		Branch:         prData.Head.Ref,
+		PRLink:         pr.PRLink,
+		ReviewStatus:   fetched.reviewStatus,
+		Reviewers:      fetched.reviewers,
+		CIState:        fetched.ciState,
+		FailingChecks:  fetched.failingChecks,
+		AutoDiscovered: pr.AutoDiscovered,
+	}, nil
+}
+
+// snapshotFiles converts GitHub's file list into rows for a PRSnapshot
+func snapshotFiles(prID uint, files []dto.GitHubPRFile) []domain.PRSnapshotFile {
+	rows := make([]domain.PRSnapshotFile, 0, len(files))
+	for _, f := range files {
+		rows = append(rows, domain.PRSnapshotFile{
+			PRID:      prID,
+			Path:      f.Filename,
+			Status:    f.Status,
+			Additions: f.Additions,
+			Deletions: f.Deletions,
+		})
+	}
+	return rows
+}
+
+// prCommits converts GitHub's commit list into rows for a PullRequest
+func prCommits(prID uint, commits []dto.GitHubCommit) []domain.PRCommit {
+	rows := make([]domain.PRCommit, 0, len(commits))
+	for _, c := range commits {
+		headline, _, _ := strings.Cut(c.Commit.Message, "\n")
+		row := domain.PRCommit{
+			PRID:       prID,
+			SHA:        c.SHA,
+			AuthorName: c.Commit.Author.Name,
+			Headline:   strings.TrimSpace(headline),
+			AuthoredAt: c.Commit.Author.Date,
+		}
+		if c.Author != nil {
+			row.AuthorLogin = c.Author.Login
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// splitList splits a comma separated column back into its values
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// errInvalidPRLink marks PRs whose stored link could not be parsed
+var errInvalidPRLink = errors.New("invalid PR link format")
+
+// classifyFetchError maps a fetch error to one of the dto.Failure* categories
+func classifyFetchError(err error) string {
+	var apiErr *github.APIError
+	var providerErr *provider.HTTPError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errInvalidPRLink):
+		return dto.FailureBadLink
+	case errors.Is(err, github.ErrRateLimited):
+		return dto.FailureRateLimited
+	case errors.Is(err, github.ErrNoToken):
+		return dto.FailureAuth
+	case errors.As(err, &apiErr):
+		switch apiErr.StatusCode {
+		case http.StatusNotFound, http.StatusGone:
+			return dto.FailureNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return dto.FailureAuth
+		}
+		return dto.FailureUnknown
+	case errors.As(err, &providerErr):
+		switch providerErr.StatusCode {
+		case http.StatusNotFound, http.StatusGone:
+			return dto.FailureNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return dto.FailureAuth
+		case http.StatusTooManyRequests:
+			return dto.FailureRateLimited
+		}
+		return dto.FailureUnknown
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return dto.FailureNetwork
+	}
+	return dto.FailureUnknown
+}
+
+// fetchPRData makes a conditional request with the PR's stored validators and,
+// on 304 Not Modified, rebuilds the data from the latest snapshot instead.
+func (s *prRefresherImpl) fetchPRData(ctx context.Context, pr *domain.PullRequest, owner, repo string, prNumber int) (*dto.GitHubPRResponse, error) {
+	cache := github.CacheValidators{ETag: pr.ETag, LastModified: pr.LastModified}
+
+	// Without a previous snapshot, or a row stored before titles and base
+	// branches were kept, there is nothing to reuse, so fetch in full
+	snap, err := s.prRepo.GetLatestSnapshot(pr.ID)
+	if err != nil || pr.Title == "" || pr.BaseBranch == "" {
+		cache = github.CacheValidators{}
+	}
+
+	prData, err := s.ghClient.FetchPRDetailsIfChanged(ctx, owner, repo, prNumber, cache)
+	if !errors.Is(err, github.ErrNotModified) {
+		return prData, err
+	}
+	log.Info().Msgf("PR %s not modified since last fetch, reusing snapshot", pr.PRLink)
+
+	state := "open"
+	if pr.Status == domain.PRStatusMerged || pr.Status == domain.PRStatusClosed {
+		state = "closed"
+	}
+	prData = &dto.GitHubPRResponse{
+		Title:        pr.Title,
+		Body:         snap.Description,
+		State:        state,
+		Draft:        pr.IsDraft,
+		Merged:       pr.Status == domain.PRStatusMerged,
+		MergedAt:     pr.MergedAt,
+		ClosedAt:     pr.ClosedAt,
+		ChangedFiles: snap.FilesChanged,
+		Additions:    snap.LinesAdded,
+		Deletions:    snap.LinesRemoved,
+		Commits:      snap.CommitCount,
+		ETag:         pr.ETag,
+		LastModified: pr.LastModified,
+		NotModified:  true,
+	}
+	prData.Base.Ref = pr.BaseBranch
+	prData.Head.Ref = pr.BranchName
+	prData.Head.SHA = pr.HeadSHA
+	return prData, nil
+}
+
+// applyPRStatus copies the canonical status, draft flag and merged/closed times
+// from GitHub onto pr, returning the transition to record when the status changed
+func applyPRStatus(pr *domain.PullRequest, prData *dto.GitHubPRResponse) *domain.PRStatusTransition {
+	status := github.PRStatus(prData)
+	var transition *domain.PRStatusTransition
+	if pr.Status != status {
+		transition = &domain.PRStatusTransition{
+			PRID:       pr.ID,
+			FromStatus: pr.Status,
+			ToStatus:   status,
+			ChangedAt:  time.Now(),
+		}
+		log.Info().Msgf("PR %s status changed from %q to %q", pr.PRLink, pr.Status, status)
+	}
+
+	pr.Status = status
+	pr.IsDraft = prData.Draft
+	pr.MergedAt = prData.MergedAt
+	pr.ClosedAt = prData.ClosedAt
+	return transition
+}
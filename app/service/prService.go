@@ -6,11 +6,10 @@ import (
 	"net/http"
 	"pr-mail/app/domain"
 	"pr-mail/app/dto"
-	github "pr-mail/app/github"
-	helper "pr-mail/app/helper"
+	"pr-mail/app/provider"
 	"pr-mail/app/repo"
 	"pr-mail/pkg/e"
-	"pr-mail/pkg/jwt"
+	"pr-mail/pkg/password"
 	"pr-mail/pkg/smtp"
 	"time"
 
@@ -23,16 +22,25 @@ type PrService interface {
 	SaveEmployeePR(r *http.Request) error
 	GeneratePRDetails(r *http.Request) (*dto.PRDetailsResponse, error)
 	GeneratePRReport(r *http.Request) (*dto.PRReportResponse, error)
+	GetPRFiles(r *http.Request) (*dto.PRFilesResponse, error)
 	SendPRMail(r *http.Request) error
 }
 
 type prServiceImpl struct {
-	prRepo repo.PrRepo
+	prRepo    repo.PrRepo
+	refresher PRRefresher
+	providers *provider.Registry
+	auth      AuthService
+	access    AccessService
 }
 
-func NewPrService(prRepo repo.PrRepo) PrService {
+func NewPrService(prRepo repo.PrRepo, refresher PRRefresher, providers *provider.Registry, auth AuthService, access AccessService) PrService {
 	return &prServiceImpl{
-		prRepo: prRepo,
+		prRepo:    prRepo,
+		refresher: refresher,
+		providers: providers,
+		auth:      auth,
+		access:    access,
 	}
 }
 
@@ -69,28 +77,25 @@ func (s *prServiceImpl) LoginUser(r *http.Request) (*dto.LoginResponse, error) {
 	}
 
 	// Validate password
-	if details.Password != args.Password {
+	ok, needsRehash := password.Verify(details.Password, args.Password)
+	if !ok {
 		err := fmt.Errorf("invalid password for user %s", details.Username)
 		return nil, e.NewError(e.ErrInvaliPassword, "invalid password", err)
 	}
 
-	// Generating JWT Token
-	token, err := jwt.GenerateToken(int64(details.ID), details.Username)
-	if err != nil {
-		return nil, e.NewError(e.ErrTokenNotGenerated, "failed to generate token", err)
-	}
-
-	fmt.Printf("the token is %s : \n ", token)
-
-	// Save token to DB
-	err = s.prRepo.UpdateUserToken(details.ID, token)
-	if err != nil {
-		return nil, e.NewError(e.ErrTokenNotSaved, "failed to save token", err)
+	// Upgrade plaintext or weaker hashes now that we have the password
+	if needsRehash {
+		if hash, err := password.Hash(args.Password); err != nil {
+			log.Error().Err(err).Msgf("Failed to hash password for %s", details.Username)
+		} else if err := s.prRepo.UpdateUserPassword(details.ID, hash); err != nil {
+			log.Error().Err(err).Msgf("Failed to upgrade stored password for %s", details.Username)
+		} else {
+			log.Info().Msgf("Upgraded stored password for %s", details.Username)
+		}
 	}
 
-	return &dto.LoginResponse{
-		Token: token,
-	}, nil
+	// Access and refresh token
+	return s.auth.StartSession(details)
 }
 
 func (s *prServiceImpl) SaveEmployeePR(r *http.Request) error {
@@ -109,6 +114,15 @@ func (s *prServiceImpl) SaveEmployeePR(r *http.Request) error {
 	}
 	log.Info().Msg("Successfully parsed and validated SaveEmployeePR request")
 
+	// Check every employee up front so a forbidden one doesn't leave a partial save
+	staffIDs := make([]string, 0, len(args.PRs))
+	for _, pr := range args.PRs {
+		staffIDs = append(staffIDs, pr.StaffID)
+	}
+	if err := s.access.Authorize(r.Context(), staffIDs...); err != nil {
+		return err
+	}
+
 	now := time.Now()
 
 	// Process each PR
@@ -120,6 +134,13 @@ func (s *prServiceImpl) SaveEmployeePR(r *http.Request) error {
 			//continue // Skip and continue with other PRs
 		}
 
+		// Store one canonical spelling so the same PR can't be saved twice
+		_, link, err := s.providers.Normalize(pr.PRLink)
+		if err != nil {
+			return e.NewError(e.ErrInvalidPRLink, fmt.Sprintf("invalid PR link %q", pr.PRLink), err)
+		}
+		pr.PRLink = link
+
 		existingPR, err := s.prRepo.GetPRByEmpIDAndLink(employee.ID, pr.PRLink)
 		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 			return e.NewError(e.ErrPrCheckingFailed, "failed to check existing PR", err)
@@ -127,6 +148,7 @@ func (s *prServiceImpl) SaveEmployeePR(r *http.Request) error {
 
 		if existingPR != nil {
 			existingPR.PRLink = pr.PRLink
+			existingPR.AutoDiscovered = false // confirmed by the employee
 			existingPR.UpdatedAt = &now
 			err := s.prRepo.UpdatePullRequest(existingPR)
 			if err != nil {
@@ -138,12 +160,12 @@ func (s *prServiceImpl) SaveEmployeePR(r *http.Request) error {
 			continue
 		}
 
-		// Save new PR
+		// Save new PR, open until the first fetch so sync and generate pick it up
 		newPR := &domain.PullRequest{
 			EmployeeID: employee.ID,
 			StaffID:    pr.StaffID,
 			PRLink:     pr.PRLink,
-			Status:     pr.Status,
+			Status:     domain.PRStatusOpen,
 			CreatedAt:  &now,
 			UpdatedAt:  &now,
 		}
@@ -177,6 +199,10 @@ func (s *prServiceImpl) GeneratePRDetails(r *http.Request) (*dto.PRDetailsRespon
 	}
 	log.Info().Msg("Successfully completed parsing and validation of request body")
 
+	if err := s.access.Authorize(r.Context(), args.StaffID); err != nil {
+		return nil, err
+	}
+
 	// Fetch all PRs for this employee with status open or draft
 	prs, err := s.prRepo.GetAllOpenOrDraftPRsByEmpID(args.StaffID)
 	if err != nil || len(prs) == 0 {
@@ -184,72 +210,7 @@ func (s *prServiceImpl) GeneratePRDetails(r *http.Request) (*dto.PRDetailsRespon
 	}
 	log.Info().Msgf("Found %d open/draft PRs", len(prs))
 
-	var prDetailsList []dto.SinglePRDetails
-
-	for _, pr := range prs {
-		// Extract repo owner/name and PR number from pr.PRLink
-		owner, repo, prNumber, err := helper.ParsePRLink(pr.PRLink)
-		if err != nil {
-			log.Error().Err(err).Msgf("Invalid PR link format: %s", pr.PRLink)
-			continue // skip this PR
-		}
-		log.Info().Msgf("Processing PR: owner=%s, repo=%s, prNumber=%d", owner, repo, prNumber)
-
-		// GitHub API to fetch PR details
-		prData, err := github.FetchPRDetails(owner, repo, prNumber)
-		if err != nil {
-			log.Error().Err(err).Msgf("Failed to fetch PR data from GitHub for PR: %s", pr.PRLink)
-			continue // skip this PR
-		}
-
-		// Save today's PR data to a table so we can see the daily change here
-		snapshot := &domain.PRSnapshot{
-			EmployeeID:   pr.EmployeeID,
-			Name:         owner,
-			PRID:         pr.ID,
-			Date:         time.Now().Truncate(24 * time.Hour),
-			Description:  prData.Body, // Use PR description from GitHub
-			LinesAdded:   prData.Additions,
-			LinesRemoved: prData.Deletions,
-			FilesChanged: prData.ChangedFiles,
-			CommitCount:  prData.Commits,
-		}
-		err = s.prRepo.SavePRSnapshot(snapshot)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to save daily PR details")
-		}
-
-		// Update PR with pr_number, repo_name, source_branch, and description
-		pr.PRNumber = prNumber
-		pr.RepoName = repo
-		pr.BranchName = prData.Head.Ref
-		pr.Description = prData.Body
-		err = s.prRepo.UpdatePullRequest(&pr)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to update PR details in DB")
-		}
-
-		prDetailsList = append(prDetailsList, dto.SinglePRDetails{
-			Owner:        owner,
-			Title:        prData.Title,
-			Description:  prData.Body,
-			Status:       prData.State,
-			Files:        prData.ChangedFiles,
-			LinesAdded:   prData.Additions,
-			LinesRemoved: prData.Deletions,
-			CommitCount:  prData.Commits,
-			Branch:       prData.Head.Ref,
-			PRLink:       pr.PRLink,
-		})
-	}
-
-	if len(prDetailsList) == 0 {
-		return nil, e.NewError(e.ErrResourceNotFound, "No valid PR details could be fetched for this employee", nil)
-	}
-
-	return &dto.PRDetailsResponse{
-		PRs: prDetailsList,
-	}, nil
+	return s.refresher.RefreshPRs(r.Context(), prs)
 }
 
 func (s *prServiceImpl) GeneratePRReport(r *http.Request) (*dto.PRReportResponse, error) {
@@ -268,6 +229,10 @@ func (s *prServiceImpl) GeneratePRReport(r *http.Request) (*dto.PRReportResponse
 	}
 	log.Info().Msg("Successfully completed parsing and validation of request body")
 
+	if err := s.access.Authorize(r.Context(), args.StaffID); err != nil {
+		return nil, err
+	}
+
 	// Fetch all today's PRSnapshots for this employee
 	snaps, err := s.prRepo.GetTodaySnapshotsByEmpID(args.StaffID)
 	if err != nil || len(snaps) == 0 {
@@ -324,6 +289,67 @@ func (s *prServiceImpl) GeneratePRReport(r *http.Request) (*dto.PRReportResponse
 	}, nil
 }
 
+func (s *prServiceImpl) GetPRFiles(r *http.Request) (*dto.PRFilesResponse, error) {
+	args := &dto.PRFilesRequest{}
+
+	// Parse path and query params
+	err := args.Parse(r)
+	if err != nil {
+		return nil, e.NewError(e.ErrDecodeRequestBody, "error while parsing", err)
+	}
+
+	err = args.Validate()
+	if err != nil {
+		return nil, e.NewError(e.ErrValidateRequest, "error while validating", err)
+	}
+	log.Info().Msg("Successfully completed parsing and validation of request")
+
+	if err := s.access.Authorize(r.Context(), args.StaffID); err != nil {
+		return nil, err
+	}
+
+	snaps, err := s.prRepo.GetSnapshotsByEmpIDAndDate(args.StaffID, args.Date)
+	if err != nil || len(snaps) == 0 {
+		return nil, e.NewError(e.ErrResourceNotFound, "No PR snapshots found for this employee on that date", err)
+	}
+
+	resp := &dto.PRFilesResponse{
+		EmpID: args.StaffID,
+		Date:  args.Date.Format("2006-01-02"),
+		PRs:   []dto.PRFileList{},
+	}
+	for _, snap := range snaps {
+		pr, err := s.prRepo.GetPRByID(snap.PRID)
+		if err != nil {
+			log.Error().Err(err).Msgf("No PR found for snapshot PRID: %d", snap.PRID)
+			continue
+		}
+
+		files, err := s.prRepo.GetSnapshotFiles(snap.ID)
+		if err != nil {
+			return nil, e.NewError(e.ErrExecuteSQL, "Failed to fetch changed files", err)
+		}
+
+		list := dto.PRFileList{
+			PRLink:    pr.PRLink,
+			Title:     pr.Title,
+			Truncated: snap.FilesTruncated,
+			Files:     make([]dto.PRFile, 0, len(files)),
+		}
+		for _, f := range files {
+			list.Files = append(list.Files, dto.PRFile{
+				Path:      f.Path,
+				Status:    f.Status,
+				Additions: f.Additions,
+				Deletions: f.Deletions,
+			})
+		}
+		resp.PRs = append(resp.PRs, list)
+	}
+
+	return resp, nil
+}
+
 func (s *prServiceImpl) SendPRMail(r *http.Request) error {
 	args := &dto.SendMailRequest{}
 
@@ -339,6 +365,10 @@ func (s *prServiceImpl) SendPRMail(r *http.Request) error {
 	}
 	log.Info().Msg("Successfully completed parsing and validation of request body")
 
+	if err := s.access.Authorize(r.Context(), args.StaffID...); err != nil {
+		return err
+	}
+
 	// Fetch reports
 	reports, err := s.prRepo.FetchReportsByStaffID(args.StaffID)
 	if err != nil {
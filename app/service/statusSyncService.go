@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"os"
+	"pr-mail/app/domain"
+	"pr-mail/app/dto"
+	"pr-mail/app/repo"
+	"pr-mail/pkg/e"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultStatusSyncInterval is how often open PRs are rechecked by default
+const DefaultStatusSyncInterval = time.Hour
+
+// StatusSyncIntervalFromEnv reads PR_STATUS_SYNC_INTERVAL (a Go duration such
+// as 30m), where 0 turns the scheduled sync off
+func StatusSyncIntervalFromEnv() time.Duration {
+	if v := os.Getenv("PR_STATUS_SYNC_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Warn().Msgf("Invalid PR_STATUS_SYNC_INTERVAL %q, using %s", v, DefaultStatusSyncInterval)
+	}
+	return DefaultStatusSyncInterval
+}
+
+// StatusSyncService rechecks every open and draft PR so merged and closed ones
+// stop showing up as open in reports
+type StatusSyncService interface {
+	SyncPRStatuses(ctx context.Context) (*dto.StatusSyncResponse, error)
+}
+
+type statusSyncServiceImpl struct {
+	prRepo    repo.PrRepo
+	refresher PRRefresher
+}
+
+func NewStatusSyncService(prRepo repo.PrRepo, refresher PRRefresher) StatusSyncService {
+	return &statusSyncServiceImpl{
+		prRepo:    prRepo,
+		refresher: refresher,
+	}
+}
+
+func (s *statusSyncServiceImpl) SyncPRStatuses(ctx context.Context) (*dto.StatusSyncResponse, error) {
+	prs, err := s.prRepo.GetAllOpenOrDraftPRs()
+	if err != nil {
+		return nil, e.NewError(e.ErrExecuteSQL, "failed to load open PRs", err)
+	}
+
+	// Only the PRs themselves are fetched, the refresher stores the new status,
+	// timestamps and title and records the transition
+	result, err := s.refresher.RecheckStatuses(ctx, prs)
+	if err != nil {
+		return nil, err
+	}
+
+	closed := 0
+	for _, change := range result.Changed {
+		if change.ToStatus == domain.PRStatusMerged || change.ToStatus == domain.PRStatusClosed {
+			closed++
+		}
+	}
+	log.Info().Msgf("Status sync checked %d PRs, %d changed, %d merged or closed, %d failed",
+		result.Checked, len(result.Changed), closed, len(result.Failures))
+	return result, nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pr-mail/app/domain"
+	"pr-mail/app/dto"
+	"pr-mail/app/provider"
+	"pr-mail/app/repo"
+	"pr-mail/pkg/e"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// webhookRefreshTimeout bounds the background refresh started by a delivery
+const webhookRefreshTimeout = 5 * time.Minute
+
+type WebhookService interface {
+	HandleGitHubWebhook(r *http.Request) (*dto.WebhookResponse, error)
+}
+
+type webhookServiceImpl struct {
+	webhookRepo repo.WebhookRepo
+	prRepo      repo.PrRepo
+	refresher   PRRefresher
+	providers   *provider.Registry
+	secret      []byte
+}
+
+func NewWebhookService(webhookRepo repo.WebhookRepo, prRepo repo.PrRepo, refresher PRRefresher, providers *provider.Registry, secret string) WebhookService {
+	return &webhookServiceImpl{
+		webhookRepo: webhookRepo,
+		prRepo:      prRepo,
+		refresher:   refresher,
+		providers:   providers,
+		secret:      []byte(secret),
+	}
+}
+
+func (s *webhookServiceImpl) HandleGitHubWebhook(r *http.Request) (*dto.WebhookResponse, error) {
+	args := &dto.GitHubWebhookRequest{}
+
+	// Parse headers and raw body, the signature covers the exact bytes sent
+	err := args.Parse(r)
+	if err != nil {
+		return nil, e.NewError(e.ErrDecodeRequestBody, "error while parsing", err)
+	}
+
+	err = args.Validate()
+	if err != nil {
+		return nil, e.NewError(e.ErrWebhookPayload, "error while validating", err)
+	}
+
+	err = s.verifySignature(args.Signature, args.Body)
+	if err != nil {
+		return nil, e.NewError(e.ErrInvalidSignature, "invalid webhook signature", err)
+	}
+	log.Info().Msgf("Received GitHub %s delivery %s", args.Event, args.DeliveryID)
+
+	resp := &dto.WebhookResponse{
+		Event:      args.Event,
+		DeliveryID: args.DeliveryID,
+		Status:     dto.WebhookIgnored,
+	}
+
+	var payload *dto.GitHubWebhookPayload
+	switch args.Event {
+	case "pull_request", "pull_request_review", "check_suite":
+		payload = &dto.GitHubWebhookPayload{}
+		if err := json.Unmarshal(args.Body, payload); err != nil {
+			return nil, e.NewError(e.ErrWebhookPayload, "failed to decode webhook payload", err)
+		}
+	default:
+		// ping and any other event we're subscribed to by accident
+		log.Info().Msgf("Ignoring GitHub %s event", args.Event)
+	}
+
+	// Redeliveries carry the same delivery ID, claiming it first lets only one
+	// of several concurrent deliveries through. It counts as processed only
+	// once the refresh succeeded, so redelivering a failed one retries it.
+	claimed, err := s.webhookRepo.ClaimDelivery(&domain.WebhookDelivery{
+		DeliveryID: args.DeliveryID,
+		Event:      args.Event,
+	}, webhookRefreshTimeout)
+	if err != nil {
+		return nil, e.NewError(e.ErrExecuteSQL, "failed to record webhook delivery", err)
+	}
+	if !claimed {
+		log.Info().Msgf("Delivery %s already processed, skipping", args.DeliveryID)
+		resp.Status = dto.WebhookDuplicate
+		return resp, nil
+	}
+
+	if payload == nil {
+		s.markDelivery(args.DeliveryID, domain.DeliveryProcessed)
+		return resp, nil
+	}
+
+	// Refreshing takes several GitHub calls, more than GitHub waits for a
+	// response, so it carries on after the delivery is acknowledged
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), webhookRefreshTimeout)
+	go func() {
+		defer cancel()
+		if err := s.refreshFromPayload(ctx, args.DeliveryID, payload); err != nil {
+			log.Error().Err(err).Msgf("Delivery %s failed, it will be processed again if redelivered", args.DeliveryID)
+			s.markDelivery(args.DeliveryID, domain.DeliveryFailed)
+			return
+		}
+		s.markDelivery(args.DeliveryID, domain.DeliveryProcessed)
+	}()
+	resp.Status = dto.WebhookAccepted
+
+	return resp, nil
+}
+
+func (s *webhookServiceImpl) markDelivery(deliveryID, status string) {
+	if err := s.webhookRepo.MarkDelivery(deliveryID, status); err != nil {
+		log.Error().Err(err).Msgf("Failed to mark delivery %s as %s", deliveryID, status)
+	}
+}
+
+// refreshFromPayload refreshes every PR the event refers to. A PR opened by an
+// employee that nobody saved yet is tracked first, as auto-discovered. It
+// fails when any PR couldn't be looked up, tracked or refreshed.
+func (s *webhookServiceImpl) refreshFromPayload(ctx context.Context, deliveryID string, payload *dto.GitHubWebhookPayload) error {
+	owner, repoName := payload.Repository.Owner.Login, payload.Repository.Name
+
+	var refs []dto.GitHubWebhookPR
+	if payload.PullRequest != nil {
+		refs = append(refs, *payload.PullRequest)
+	}
+	if payload.CheckSuite != nil {
+		refs = append(refs, payload.CheckSuite.PullRequests...)
+	}
+
+	var prs []domain.PullRequest
+	var failed []string
+	for _, ref := range refs {
+		// check_suite events list PRs without their html_url
+		link := ref.HTMLURL
+		if link == "" {
+			link = fmt.Sprintf("%s/pull/%d", payload.Repository.HTMLURL, ref.Number)
+		}
+		prRef, canonical, err := s.providers.Normalize(link)
+		if err != nil {
+			// Sent by a GitHub server other than the one configured
+			log.Warn().Err(err).Msgf("Delivery %s: dropping event for %s", deliveryID, link)
+			continue
+		}
+		link = canonical
+		matched, err := s.prRepo.GetPRsByRepoAndNumber(prRef.Host, owner, repoName, ref.Number, link)
+		if err != nil {
+			log.Error().Err(err).Msgf("Delivery %s: failed to look up %s", deliveryID, link)
+			failed = append(failed, link)
+			continue
+		}
+		if len(matched) == 0 {
+			pr, err := s.trackPR(owner, repoName, link, &ref)
+			if err != nil {
+				log.Error().Err(err).Msgf("Delivery %s: failed to track %s", deliveryID, link)
+				failed = append(failed, link)
+				continue
+			}
+			if pr == nil {
+				continue
+			}
+			matched = append(matched, *pr)
+		}
+		prs = append(prs, matched...)
+	}
+	if len(prs) == 0 && len(failed) == 0 {
+		log.Info().Msgf("Delivery %s: no tracked PRs for %s/%s, nothing to update", deliveryID, owner, repoName)
+		return nil
+	}
+
+	if len(prs) > 0 {
+		result, err := s.refresher.RefreshPRs(ctx, prs)
+		if err != nil {
+			return err
+		}
+		for _, failure := range result.Failures {
+			log.Error().Msgf("Webhook refresh failed for %s: %s", failure.PRLink, failure.Message)
+			failed = append(failed, failure.PRLink)
+		}
+		log.Info().Msgf("Delivery %s: updated %d PRs", deliveryID, len(result.PRs))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d PRs not updated: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// trackPR saves an open PR that isn't tracked yet when its author is an
+// employee, matched by GitHub login. It returns nil when the PR is dropped.
+func (s *webhookServiceImpl) trackPR(owner, repoName, link string, ref *dto.GitHubWebhookPR) (*domain.PullRequest, error) {
+	if ref.State != "open" || ref.User.Login == "" {
+		// check_suite events carry no author, closed PRs aren't worth tracking
+		log.Info().Msgf("Dropping event for untracked PR %s", link)
+		return nil, nil
+	}
+	emp, err := s.prRepo.GetEmployeeByGitHubLogin(ref.User.Login)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Info().Msgf("Dropping event for untracked PR %s, author %s is not an employee", link, ref.User.Login)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := domain.PRStatusOpen
+	if ref.Draft {
+		status = domain.PRStatusDraft
+	}
+	now := time.Now()
+	pr := &domain.PullRequest{
+		EmployeeID:     emp.ID,
+		StaffID:        emp.EmpID,
+		PRLink:         link,
+		RepoOwner:      owner,
+		RepoName:       repoName,
+		PRNumber:       ref.Number,
+		Title:          ref.Title,
+		Status:         status,
+		IsDraft:        ref.Draft,
+		AutoDiscovered: true,
+		CreatedAt:      &now,
+		UpdatedAt:      &now,
+	}
+	if err := s.prRepo.SavePullRequest(pr); err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Tracking PR %s for %s from webhook", link, emp.EmpID)
+	return pr, nil
+}
+
+// verifySignature checks X-Hub-Signature-256, the hex HMAC-SHA256 of the body
+func (s *webhookServiceImpl) verifySignature(signature string, body []byte) error {
+	if len(s.secret) == 0 {
+		return errors.New("GITHUB_WEBHOOK_SECRET not set in environment")
+	}
+
+	hexSig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return errors.New("signature is not sha256")
+	}
+	got, err := hex.DecodeString(hexSig)
+	if err != nil {
+		return fmt.Errorf("signature is not hex: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("signature does not match payload")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	gormdb "pr-mail/app/gorm_db"
+	"pr-mail/app/repo"
+	"pr-mail/app/service"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(adminPasswordCmd)
+}
+
+var adminPasswordCmd = &cobra.Command{
+	Use:   "admin-password <username>",
+	Short: "Set or reset an admin password",
+	Long:  "Reads the new password from the first line of stdin, stores its hash and creates the admin if the username is new. The admin's existing sessions are ended.",
+	Args:  cobra.ExactArgs(1),
+	Run:   SetAdminPassword,
+}
+
+func SetAdminPassword(_ *cobra.Command, args []string) {
+	// Read from stdin rather than a flag so the password stays out of shell history and ps
+	fmt.Fprint(os.Stderr, "New password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalf("failed to read password: %v", err)
+	}
+	plain := strings.TrimRight(line, "\r\n")
+
+	db, err := gormdb.ConnectDb()
+	if err != nil {
+		log.Fatalf("failed to connect to the database: %v", err)
+	}
+
+	created, err := service.NewAdminService(repo.NewPrRepo(db)).SetPassword(args[0], plain)
+	if err != nil {
+		log.Fatalf("failed to set password: %v", err)
+	}
+
+	if created {
+		fmt.Printf("Created admin %s\n", args[0])
+		return
+	}
+	fmt.Printf("Password updated for %s\n", args[0])
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	gormdb "pr-mail/app/gorm_db"
+	"pr-mail/app/repo"
+	"pr-mail/app/service"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(adminRoleCmd)
+}
+
+var adminRoleCmd = &cobra.Command{
+	Use:   "admin-role <username> <admin|manager|employee> [staff_id]",
+	Short: "Set the role of a login",
+	Long:  "Sets the role of an existing login. Managers and employees need the staff ID of the employee they log in as, managers can act for the employees whose manager_emp_id is that staff ID. Tokens issued with the old role are rejected, the new one applies from the next refresh.",
+	Args:  cobra.RangeArgs(2, 3),
+	Run:   SetAdminRole,
+}
+
+func SetAdminRole(_ *cobra.Command, args []string) {
+	staffID := ""
+	if len(args) == 3 {
+		staffID = args[2]
+	}
+
+	db, err := gormdb.ConnectDb()
+	if err != nil {
+		log.Fatalf("failed to connect to the database: %v", err)
+	}
+
+	if err := service.NewAdminService(repo.NewPrRepo(db)).SetRole(args[0], args[1], staffID); err != nil {
+		log.Fatalf("failed to set role: %v", err)
+	}
+	fmt.Printf("Role of %s set to %s\n", args[0], args[1])
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"pr-mail/app"
 	gormdb "pr-mail/app/gorm_db"
 	"pr-mail/pkg/api"
@@ -32,7 +33,11 @@ func StartAPI(*cobra.Command, []string) {
 		log.Fatalf("failed to connect to the database: %v", err)
 	}
 
-	r := app.APIRouter(db)
+	// One GitHub client for the API and the jobs, so they share its tokens' state
+	deps := app.NewDeps(db)
+	app.StartJobs(context.Background(), db, deps)
+
+	r := app.APIRouter(db, deps)
 	api.Start(r)
 
 }
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"pr-mail/app"
+	gormdb "pr-mail/app/gorm_db"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(discoverCmd)
+}
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Track open PRs authored by employees",
+	Long:  "Searches GITHUB_DISCOVERY_ORGS for open PRs by each employee's GitHub login and saves the missing ones as auto-discovered",
+	Run:   RunDiscovery,
+}
+
+func RunDiscovery(*cobra.Command, []string) {
+	db, err := gormdb.ConnectDb()
+	if err != nil {
+		log.Fatalf("failed to connect to the database: %v", err)
+	}
+
+	result, err := app.RunDiscovery(context.Background(), db)
+	if err != nil {
+		log.Fatalf("PR discovery failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"pr-mail/app"
+	gormdb "pr-mail/app/gorm_db"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Recheck the status of every open and draft PR",
+	Long:  "Fetches every open and draft PR, storing merged and closed ones with their timestamps and recording each status change",
+	Run:   RunStatusSync,
+}
+
+func RunStatusSync(*cobra.Command, []string) {
+	db, err := gormdb.ConnectDb()
+	if err != nil {
+		log.Fatalf("failed to connect to the database: %v", err)
+	}
+
+	result, err := app.RunStatusSync(context.Background(), db)
+	if err != nil {
+		log.Fatalf("PR status sync failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		log.Fatal(err)
+	}
+}
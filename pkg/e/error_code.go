@@ -59,6 +59,33 @@ const (
 
 	// ErrFetchingPRReport : error when we try to sending  mail.
 	ErrSendMail
+
+	// ErrWebhookPayload : error when a webhook delivery is missing headers or can't be decoded
+	ErrWebhookPayload
+
+	// ErrInvalidPRLink : error when a PR link isn't a pull or merge request on a known host
+	ErrInvalidPRLink
+)
+
+// 401 errors
+const (
+	// ErrUnauthorized : when the caller could not be authenticated
+	ErrUnauthorized int = 401000 + iota
+
+	// ErrInvalidSignature : when a webhook delivery's signature does not match the shared secret
+	ErrInvalidSignature
+
+	// ErrInvalidRefreshToken : when a refresh token is unknown, expired or revoked
+	ErrInvalidRefreshToken
+
+	// ErrRefreshTokenReused : when an already used refresh token is presented again
+	ErrRefreshTokenReused
+)
+
+// 403 errors
+const (
+	// ErrForbidden : when the caller's role or staff scope does not allow the request
+	ErrForbidden int = 403000 + iota
 )
 
 // 404 errors
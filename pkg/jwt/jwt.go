@@ -1,7 +1,10 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -9,44 +12,150 @@ import (
 
 var (
 	ErrExpiredToken = errors.New("token is expired")
-	jwtKey          = []byte("your_secret_key") // change this to a secure env var in prod
+	ErrInvalidToken = errors.New("token is invalid")
 )
 
 type Claims struct {
 	AdminID  int64  `json:"id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	// StaffID is the employee a manager or employee login belongs to
+	StaffID string `json:"staff_id,omitempty"`
 	jwt.StandardClaims
 }
 
-// GenerateToken generates a new JWT token for admin
-func GenerateToken(adminID int64, username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// TokenManager issues and validates admin JWTs with the configured keys
+type TokenManager interface {
+	// GenerateToken generates a new access token for a login, the returned claims
+	// carry its jti and expiry for revocation
+	GenerateToken(adminID int64, username, role, staffID string) (string, *Claims, error)
+	// ValidateToken validates the JWT token
+	ValidateToken(tokenStr string) (*Claims, error)
+}
+
+type tokenManagerImpl struct {
+	signing  Key
+	keys     map[string]Key // by kid
+	methods  []string       // algorithms of the configured keys
+	issuer   string
+	audience string
+	ttl      time.Duration
+}
+
+func NewTokenManager(cfg Config) (TokenManager, error) {
+	m := &tokenManagerImpl{
+		keys:     map[string]Key{},
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		ttl:      cfg.TokenTTL,
+	}
+	if m.ttl <= 0 {
+		m.ttl = DefaultTokenTTL
+	}
+
+	seen := map[string]bool{}
+	for _, key := range cfg.Keys {
+		if _, dup := m.keys[key.ID]; dup {
+			return nil, fmt.Errorf("duplicate JWT key id %q", key.ID)
+		}
+		m.keys[key.ID] = key
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			m.methods = append(m.methods, alg)
+		}
+	}
+
+	signing, ok := m.keys[cfg.SigningKeyID]
+	if !ok {
+		return nil, fmt.Errorf("JWT signing key %q is not configured", cfg.SigningKeyID)
+	}
+	if signing.Sign == nil {
+		return nil, fmt.Errorf("JWT signing key %q has no private key", cfg.SigningKeyID)
+	}
+	m.signing = signing
+	return m, nil
+}
+
+func (m *tokenManagerImpl) GenerateToken(adminID int64, username, role, staffID string) (string, *Claims, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
 	claims := &Claims{
 		AdminID:  adminID,
 		Username: username,
+		Role:     role,
+		StaffID:  staffID,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+			Issuer:    m.issuer,
+			Audience:  m.audience,
+			Subject:   fmt.Sprintf("%d", adminID),
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(m.ttl).Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	token := jwt.NewWithClaims(m.signing.Method, claims)
+	token.Header["kid"] = m.signing.ID
+	signed, err := token.SignedString(m.signing.Sign)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
-// ValidateToken validates the JWT token
-func ValidateToken(tokenStr string) (*Claims, error) {
+// ValidateToken accepts a token only when its kid names a configured key, its
+// alg is that key's algorithm and its issuer, audience and expiry check out
+func (m *tokenManagerImpl) ValidateToken(tokenStr string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	parser := &jwt.Parser{ValidMethods: m.methods}
+	token, err := parser.ParseWithClaims(tokenStr, claims, m.keyFor)
+	if err != nil {
+		var vErr *jwt.ValidationError
+		if errors.As(err, &vErr) && vErr.Errors == jwt.ValidationErrorExpired {
+			return nil, ErrExpiredToken
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !token.Valid {
+		return nil, ErrInvalidToken
+	}
 
-	if err != nil || !token.Valid {
-		return nil, err
+	switch {
+	case claims.ExpiresAt == 0:
+		return nil, fmt.Errorf("%w: missing expiry", ErrInvalidToken)
+	case claims.Id == "":
+		return nil, fmt.Errorf("%w: missing token id", ErrInvalidToken)
+	case !claims.VerifyIssuer(m.issuer, true):
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	case !claims.VerifyAudience(m.audience, true):
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
 	}
+	return claims, nil
+}
 
-	if claims.ExpiresAt < time.Now().Unix() {
-		return nil, ErrExpiredToken
+// keyFor picks the verification key named by the kid header, rejecting tokens
+// signed with any other algorithm than that key's, e.g. HS256 with an RSA public key
+func (m *tokenManagerImpl) keyFor(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := m.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not accept %s", kid, token.Method.Alg())
 	}
+	return key.Verify, nil
+}
 
-	return claims, nil
+// newTokenID returns a random 128 bit jti
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
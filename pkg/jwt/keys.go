@@ -0,0 +1,188 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	DefaultIssuer   = "pr-mail"
+	DefaultAudience = "pr-mail-api"
+	DefaultTokenTTL = 15 * time.Minute
+
+	// minHMACSecretLength is the shortest HS256 secret accepted, 256 bits
+	minHMACSecretLength = 32
+
+	minRSAKeyBits = 2048
+)
+
+// Key is one signing or verification key, identified by the kid header
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Sign is the private key or HMAC secret, nil for keys that only verify old tokens
+	Sign interface{}
+	// Verify is the public key or HMAC secret
+	Verify interface{}
+}
+
+type Config struct {
+	// Keys accepted when verifying, the one named by SigningKeyID also signs
+	Keys         []Key
+	SigningKeyID string
+	Issuer       string
+	Audience     string
+	TokenTTL     time.Duration
+}
+
+// ConfigFromEnv loads keys from JWT_KEYS, a ';' separated list of kid:ALG:path
+// entries where path holds the HMAC secret for HS256 or a PEM key for RS256 and
+// EdDSA (a private key signs and verifies, a public key only verifies).
+// JWT_SIGNING_KEY_ID picks the signing key, defaulting to the first entry.
+// A single HS256 key can be given as JWT_SECRET instead. JWT_ISSUER, JWT_AUDIENCE
+// and JWT_TOKEN_TTL override the defaults.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		SigningKeyID: os.Getenv("JWT_SIGNING_KEY_ID"),
+		Issuer:       envOr("JWT_ISSUER", DefaultIssuer),
+		Audience:     envOr("JWT_AUDIENCE", DefaultAudience),
+		TokenTTL:     DefaultTokenTTL,
+	}
+	if v := os.Getenv("JWT_TOKEN_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid JWT_TOKEN_TTL %q", v)
+		}
+		cfg.TokenTTL = d
+	}
+
+	for _, entry := range strings.Split(os.Getenv("JWT_KEYS"), ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return Config{}, fmt.Errorf("invalid JWT_KEYS entry %q, expected kid:ALG:path", entry)
+		}
+		data, err := os.ReadFile(parts[2])
+		if err != nil {
+			return Config{}, fmt.Errorf("read key %s: %w", parts[0], err)
+		}
+		key, err := ParseKey(parts[0], parts[1], data)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Keys = append(cfg.Keys, key)
+	}
+
+	if len(cfg.Keys) == 0 {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return Config{}, errors.New("no JWT keys configured, set JWT_KEYS or JWT_SECRET")
+		}
+		key, err := ParseKey("default", "HS256", []byte(secret))
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Keys = append(cfg.Keys, key)
+	}
+
+	if cfg.SigningKeyID == "" {
+		cfg.SigningKeyID = cfg.Keys[0].ID
+	}
+	return cfg, nil
+}
+
+// ParseKey builds a Key for alg from an HMAC secret or a PEM encoded key
+func ParseKey(id, alg string, data []byte) (Key, error) {
+	if id == "" {
+		return Key{}, errors.New("JWT key id is required")
+	}
+
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) < minHMACSecretLength {
+			return Key{}, fmt.Errorf("JWT key %s: HS256 secret must be at least %d bytes", id, minHMACSecretLength)
+		}
+		return Key{ID: id, Method: jwt.SigningMethodHS256, Sign: secret, Verify: secret}, nil
+
+	case jwt.SigningMethodRS256.Alg(), SigningMethodEdDSA.Alg():
+		signer, public, err := parsePEMKey(data)
+		if err != nil {
+			return Key{}, fmt.Errorf("JWT key %s: %w", id, err)
+		}
+		key := Key{ID: id, Verify: public}
+		switch public.(type) {
+		case *rsa.PublicKey:
+			key.Method = jwt.SigningMethodRS256
+		case ed25519.PublicKey:
+			key.Method = SigningMethodEdDSA
+		}
+		if key.Method == nil || key.Method.Alg() != alg {
+			return Key{}, fmt.Errorf("JWT key %s: key type does not match %s", id, alg)
+		}
+		if rsaKey, ok := public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSAKeyBits {
+			return Key{}, fmt.Errorf("JWT key %s: RSA keys must be at least %d bits", id, minRSAKeyBits)
+		}
+		if signer != nil {
+			key.Sign = signer
+		}
+		return key, nil
+	}
+	return Key{}, fmt.Errorf("JWT key %s: unsupported algorithm %q", id, alg)
+}
+
+// parsePEMKey reads a PKCS#8, PKCS#1 or PKIX key, returning the private key
+// when there is one and the public key always
+func parsePEMKey(data []byte) (crypto.Signer, crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY", "RSA PRIVATE KEY":
+		var key interface{}
+		var err error
+		if block.Type == "RSA PRIVATE KEY" {
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else {
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, nil, errors.New("unsupported private key type")
+		}
+		return signer, signer.Public(), nil
+
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		return nil, public, err
+
+	case "RSA PUBLIC KEY":
+		public, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		return nil, public, err
+	}
+	return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -2,9 +2,12 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"pr-mail/pkg/api"
+	"pr-mail/pkg/e"
 	"pr-mail/pkg/jwt"
+	"pr-mail/pkg/rbac"
 	"strings"
 )
 
@@ -13,10 +16,23 @@ type contextKey string
 const (
 	AdminIDKey   contextKey = "admin_id"
 	AdminNameKey contextKey = "admin_username"
+	ClaimsKey    contextKey = "token_claims"
 )
 
+// SessionChecker confirms a validly signed token has not been revoked by a
+// logout, a password change or deactivating the admin
+type SessionChecker interface {
+	CheckSession(ctx context.Context, claims *jwt.Claims) error
+}
+
 // JWTAuthMiddleware for verifying admin JWT token
-func JWTAuthMiddleware(next http.Handler) http.Handler {
+func JWTAuthMiddleware(tokens jwt.TokenManager, sessions SessionChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return jwtAuth(tokens, sessions, next)
+	}
+}
+
+func jwtAuth(tokens jwt.TokenManager, sessions SessionChecker, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -30,7 +46,7 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		claims, err := jwt.ValidateToken(tokenString)
+		claims, err := tokens.ValidateToken(tokenString)
 		if err != nil {
 			if err == jwt.ErrExpiredToken {
 				api.Fail(w, http.StatusUnauthorized, 401, "Token expired, please log in again", "")
@@ -40,11 +56,42 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if err := sessions.CheckSession(r.Context(), claims); err != nil {
+			// A failure to check, such as the database being down, isn't the caller's fault
+			var appErr *e.WrapError
+			if errors.As(err, &appErr) && e.GetHttpStatusCode(appErr.ErrorCode) >= http.StatusInternalServerError {
+				api.Fail(w, http.StatusInternalServerError, appErr.ErrorCode, "Failed to check session", "")
+				return
+			}
+			api.Fail(w, http.StatusUnauthorized, 401, "Session is no longer valid, please log in again", "")
+			return
+		}
+
 		// Store admin ID and name in context
 		ctx := context.WithValue(r.Context(), AdminIDKey, claims.AdminID)
 		ctx = context.WithValue(ctx, AdminNameKey, claims.Username)
+		ctx = context.WithValue(ctx, ClaimsKey, claims)
 
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequirePermission lets a request through only when the role in its token
+// grants perm, it goes after JWTAuthMiddleware
+func RequirePermission(perm rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(ClaimsKey).(*jwt.Claims)
+			if !ok {
+				api.Fail(w, http.StatusUnauthorized, 401, "Not authenticated", "")
+				return
+			}
+			if !rbac.Can(claims.Role, perm) {
+				api.Fail(w, http.StatusForbidden, 403, "Your role is not allowed to do this", string(perm))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package password
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cost is the bcrypt work factor for new hashes, hashes below it are upgraded on login
+const Cost = 12
+
+// Hash returns the bcrypt hash to store for plain
+func Hash(plain string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plain), Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify checks plain against stored, which is a bcrypt hash or, for rows
+// written before passwords were hashed, the plaintext itself. needsRehash is
+// set on a match whose stored value should be replaced with a fresh Hash.
+func Verify(stored, plain string) (ok, needsRehash bool) {
+	if !IsHash(stored) {
+		// Compare digests so neither the content nor the length leaks through timing
+		a, b := sha256.Sum256([]byte(stored)), sha256.Sum256([]byte(plain))
+		return subtle.ConstantTimeCompare(a[:], b[:]) == 1, true
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(plain)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	return true, err != nil || cost < Cost
+}
+
+// IsHash reports whether stored is a bcrypt hash rather than a legacy plaintext password
+func IsHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
@@ -0,0 +1,50 @@
+package pool
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultConcurrency is used when a non-positive concurrency is given
+const DefaultConcurrency = 5
+
+// Result holds the output of one item, at the same index as its input
+type Result[R any] struct {
+	Value R
+	Err   error
+}
+
+// Map runs fn over items with at most concurrency calls in flight and returns
+// the results in input order. Items not yet started when ctx is cancelled get
+// ctx.Err() as their error.
+func Map[T, R any](ctx context.Context, concurrency int, items []T, fn func(ctx context.Context, item T) (R, error)) []Result[R] {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	results := make([]Result[R], len(items))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		select {
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(i int, item T) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := ctx.Err(); err != nil {
+				results[i].Err = err
+				return
+			}
+			results[i].Value, results[i].Err = fn(ctx, item)
+		}(i, item)
+	}
+
+	wg.Wait()
+	return results
+}
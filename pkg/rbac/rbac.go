@@ -0,0 +1,41 @@
+package rbac
+
+// Roles a credential can have. Credentials from before roles existed are admins.
+const (
+	RoleAdmin    = "admin"
+	RoleManager  = "manager"
+	RoleEmployee = "employee"
+)
+
+// Permission is an action a route needs, which employees it may be applied to
+// is checked separately against the caller's staff scope
+type Permission string
+
+const (
+	SavePRs         Permission = "pr:save"
+	ViewPRs         Permission = "pr:view"
+	GenerateReports Permission = "report:generate"
+	SendMail        Permission = "report:mail"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:    {SavePRs, ViewPRs, GenerateReports, SendMail},
+	RoleManager:  {SavePRs, ViewPRs, GenerateReports, SendMail},
+	RoleEmployee: {SavePRs, ViewPRs},
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can reports whether role grants perm, unknown roles grant nothing
+func Can(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package schedule
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Every runs job every interval until ctx is cancelled, the first run is one
+// interval after start so a restart doesn't trigger every job at once. Runs
+// never overlap, a run that overruns the interval delays the next one.
+func Every(ctx context.Context, name string, interval time.Duration, job func(ctx context.Context)) {
+	log.Info().Msgf("Scheduled %s every %s", name, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		job(ctx)
+	}
+}